@@ -6,8 +6,10 @@ import (
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/appengine"
 	"google.golang.org/appengine/urlfetch"
 	"net/http"
+	"time"
 )
 
 // NewClient returns an http.Client that can be used to create services from the
@@ -15,14 +17,93 @@ import (
 // The scopes parameter is used to declare the OAuth 2
 // scopes, e.g., storage.DevstorageFullControlScope.
 func NewClient(c context.Context, scopes ...string) *http.Client {
+	return NewClientWithBase(c, &urlfetch.Transport{Context: c}, scopes...)
+}
+
+// NewClientWithBase is like NewClient, but authorized requests are sent with
+// base instead of urlfetch, so that middleware such as logging, retries, or a
+// fake transport for tests can be inserted.
+func NewClientWithBase(c context.Context, base http.RoundTripper, scopes ...string) *http.Client {
+	return NewClientFromTokenSource(google.AppEngineTokenSource(c, scopes...), base)
+}
+
+// NewTokenSource returns a token source for the app's default service
+// account that reuses its token until it expires, so that it can be shared
+// by clients created with NewClientFromTokenSource instead of each fetching
+// its own token. The source is bound to c: on first generation App Engine
+// runtimes, c is only valid for the request it came from, so share the source
+// for at most the lifetime of that request. Where c outlives requests, such as
+// a background context on second generation runtimes, the source can be
+// shared for the lifetime of the instance.
+func NewTokenSource(c context.Context, scopes ...string) oauth2.TokenSource {
+	return oauth2.ReuseTokenSource(nil, google.AppEngineTokenSource(c, scopes...))
+}
+
+// NewClientFromTokenSource returns an http.Client that authorizes requests
+// with tokens from ts and sends them with base. If base is nil,
+// http.DefaultTransport is used.
+func NewClientFromTokenSource(ts oauth2.TokenSource, base http.RoundTripper) *http.Client {
 	return &http.Client{
 		Transport: &oauth2.Transport{
-			Source: google.AppEngineTokenSource(c, scopes...),
-			// Note that the App Engine urlfetch service has a limit of 10MB uploads and
-			// 32MB downloads.
-			// See https://cloud.google.com/appengine/docs/go/urlfetch/#Go_Quotas_and_limits
-			// for more information.
-			Base: &urlfetch.Transport{Context: c},
+			Source: ts,
+			Base:   base,
 		},
 	}
 }
+
+// Options configures the client returned by NewClientWithOptions.
+type Options struct {
+	// Base is the transport that carries requests once they are authorized.
+	// If nil, the App Engine urlfetch service is used, which has a limit of
+	// 10MB uploads and 32MB downloads.
+	// See https://cloud.google.com/appengine/docs/go/urlfetch/#Go_Quotas_and_limits
+	// for more information. Set Base to http.DefaultTransport to use sockets
+	// instead, which don't have those limits, on runtimes that support them
+	// (App Engine flexible and second generation standard).
+	Base http.RoundTripper
+
+	// Timeout limits the time the client waits for a request, including
+	// reading the response body. If zero, DefaultTimeout is used; if
+	// negative, there is no limit. Timeout and any deadline of c both apply,
+	// whichever is earlier. With urlfetch, the fetch itself is only bounded by
+	// c's deadline (or a 60 second default), so use a context.WithTimeout to
+	// bound the urlfetch call, not just the wait.
+	Timeout time.Duration
+}
+
+// DefaultTimeout is the Timeout used by NewClientWithOptions if none is set.
+// It matches urlfetch's default deadline.
+const DefaultTimeout = 60 * time.Second
+
+// NewClientWithOptions is like NewClient, but configured by opts. A nil opts
+// uses the zero Options, so unlike NewClient the client has a DefaultTimeout.
+func NewClientWithOptions(c context.Context, opts *Options, scopes ...string) *http.Client {
+	if opts == nil {
+		opts = &Options{}
+	}
+	base := opts.Base
+	if base == nil {
+		base = &urlfetch.Transport{Context: c}
+	}
+	client := NewClientWithBase(c, base, scopes...)
+	switch {
+	case opts.Timeout == 0:
+		client.Timeout = DefaultTimeout
+	case opts.Timeout > 0:
+		client.Timeout = opts.Timeout
+	}
+	return client
+}
+
+// NewClientForEnv is like NewClient when running on App Engine, as reported
+// by appengine.IsAppEngine (which checks for the environment variables set
+// by the App Engine runtimes). Elsewhere, such as in unit tests or on Compute
+// Engine, where urlfetch isn't available, it returns google.DefaultClient,
+// which finds Application Default Credentials and sends requests with
+// http.DefaultTransport.
+func NewClientForEnv(c context.Context, scopes ...string) (*http.Client, error) {
+	if appengine.IsAppEngine() {
+		return NewClient(c, scopes...), nil
+	}
+	return google.DefaultClient(c, scopes...)
+}
@@ -0,0 +1,111 @@
+package googleapiclient
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"google.golang.org/appengine/aetest"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordingTransport records the requests sent through it and responds with
+// an empty 200 OK.
+type recordingTransport struct {
+	requests []*http.Request
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests = append(t.requests, req)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+func TestNewClientWithBase(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	base := &recordingTransport{}
+	client := NewClientWithBase(c, base, "https://www.googleapis.com/auth/devstorage.read_only")
+	resp, err := client.Get("https://www.googleapis.com/storage/v1/b/bucket/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(base.requests) != 1 {
+		t.Fatalf("Expected 1 request through the base transport, got %v", len(base.requests))
+	}
+	req := base.requests[0]
+	if req.URL.String() != "https://www.googleapis.com/storage/v1/b/bucket/o" {
+		t.Error("Unexpected URL", req.URL)
+	}
+	if auth := req.Header.Get("Authorization"); !strings.HasPrefix(auth, "Bearer ") {
+		t.Error("Expected a bearer token in the Authorization header, got", auth)
+	}
+}
+
+func TestNewClientWithOptionsTimeout(t *testing.T) {
+	tests := []struct {
+		timeout time.Duration
+		want    time.Duration
+	}{
+		{0, DefaultTimeout},
+		{5 * time.Second, 5 * time.Second},
+		{-1, 0},
+	}
+	for _, test := range tests {
+		opts := &Options{Base: &recordingTransport{}, Timeout: test.timeout}
+		client := NewClientWithOptions(context.Background(), opts)
+		if client.Timeout != test.want {
+			t.Errorf("Timeout %v: expected client Timeout %v, got %v", test.timeout, test.want, client.Timeout)
+		}
+	}
+}
+
+// countingTokenSource returns a new token every time it's asked for one.
+type countingTokenSource struct {
+	count int
+}
+
+func (ts *countingTokenSource) Token() (*oauth2.Token, error) {
+	ts.count++
+	return &oauth2.Token{
+		AccessToken: fmt.Sprint("token", ts.count),
+		Expiry:      time.Now().Add(time.Hour),
+	}, nil
+}
+
+func TestNewClientFromTokenSource(t *testing.T) {
+	src := &countingTokenSource{}
+	ts := oauth2.ReuseTokenSource(nil, src)
+	base := &recordingTransport{}
+
+	for i := 0; i < 2; i++ {
+		client := NewClientFromTokenSource(ts, base)
+		resp, err := client.Get("https://www.googleapis.com/storage/v1/b/bucket/o")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	if src.count != 1 {
+		t.Errorf("Expected clients sharing a source to fetch 1 token, fetched %v", src.count)
+	}
+	for _, req := range base.requests {
+		if auth := req.Header.Get("Authorization"); auth != "Bearer token1" {
+			t.Error("Expected the shared token, got", auth)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package googleapiclient
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// ProgressFunc is called by Download as the response body is copied. written
+// is the number of bytes written so far and total is the response's
+// Content-Length, or -1 if it is unknown.
+type ProgressFunc func(written, total int64)
+
+// Download GETs url with client and streams the response body to w, calling
+// progress, if it isn't nil, after each chunk is written. It returns the
+// number of bytes written. A response other than 200 OK is returned as an
+// error without writing anything to w.
+//
+// Download doesn't buffer the body, but urlfetch does, and limits responses
+// to 32MB. To download larger objects, client must be created with a base
+// transport that uses sockets, such as http.DefaultTransport with
+// NewClientWithBase, which requires an environment that allows outbound
+// sockets: the second generation runtimes, or the first generation runtimes
+// with billing enabled and the sockets API. A client from
+// NewClientWithOptions has a DefaultTimeout that includes reading the body,
+// so long downloads need a negative Options.Timeout.
+func Download(c context.Context, client *http.Client, url string, w io.Writer, progress ProgressFunc) (int64, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := client.Do(req.WithContext(c))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, fmt.Errorf("googleapiclient: downloading %v: %v: %s", url, resp.Status, b)
+	}
+
+	if progress != nil {
+		w = &progressWriter{w: w, total: resp.ContentLength, progress: progress}
+	}
+	return io.Copy(w, resp.Body)
+}
+
+// progressWriter reports the bytes written through it to progress.
+type progressWriter struct {
+	w        io.Writer
+	written  int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.written += int64(n)
+	pw.progress(pw.written, pw.total)
+	return n, err
+}
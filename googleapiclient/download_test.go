@@ -0,0 +1,88 @@
+package googleapiclient
+
+import (
+	"bytes"
+	"golang.org/x/net/context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// chunkedReader returns at most size bytes per Read.
+type chunkedReader struct {
+	r    io.Reader
+	size int
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	if len(p) > cr.size {
+		p = p[:cr.size]
+	}
+	return cr.r.Read(p)
+}
+
+// chunkedTransport responds with status and body, delivered in chunks of
+// size bytes.
+type chunkedTransport struct {
+	status int
+	body   string
+	size   int
+}
+
+func (t *chunkedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		Status:        http.StatusText(t.status),
+		StatusCode:    t.status,
+		Header:        make(http.Header),
+		Body:          ioutil.NopCloser(&chunkedReader{r: strings.NewReader(t.body), size: t.size}),
+		ContentLength: int64(len(t.body)),
+		Request:       req,
+	}, nil
+}
+
+func TestDownload(t *testing.T) {
+	body := strings.Repeat("0123456789", 10)
+	client := &http.Client{Transport: &chunkedTransport{status: http.StatusOK, body: body, size: 30}}
+
+	var buf bytes.Buffer
+	var calls [][2]int64
+	n, err := Download(context.Background(), client, "https://storage.googleapis.com/bucket/object", &buf, func(written, total int64) {
+		calls = append(calls, [2]int64{written, total})
+	})
+	if err != nil {
+		t.Fatalf("Download failed. %v", err)
+	}
+	if n != int64(len(body)) || buf.String() != body {
+		t.Fatalf("Expected %v bytes %q, got %v bytes %q", len(body), body, n, buf.String())
+	}
+	expected := [][2]int64{{30, 100}, {60, 100}, {90, 100}, {100, 100}}
+	if len(calls) != len(expected) {
+		t.Fatalf("Expected progress %v, got %v", expected, calls)
+	}
+	for i := range expected {
+		if calls[i] != expected[i] {
+			t.Fatalf("Expected progress %v, got %v", expected, calls)
+		}
+	}
+
+	buf.Reset()
+	if _, err := Download(context.Background(), client, "https://storage.googleapis.com/bucket/object", &buf, nil); err != nil || buf.String() != body {
+		t.Fatalf("Expected download without a progress callback to succeed, got %q, %v", buf.String(), err)
+	}
+}
+
+func TestDownloadStatus(t *testing.T) {
+	client := &http.Client{Transport: &chunkedTransport{status: http.StatusNotFound, body: "No such object", size: 4}}
+	var buf bytes.Buffer
+	n, err := Download(context.Background(), client, "https://storage.googleapis.com/bucket/missing", &buf, func(written, total int64) {
+		t.Error("Unexpected progress callback")
+	})
+	if err == nil || !strings.Contains(err.Error(), "No such object") {
+		t.Fatalf("Expected an error with the response body, got %v", err)
+	}
+	if n != 0 || buf.Len() != 0 {
+		t.Fatalf("Expected nothing to be written, got %q", buf.String())
+	}
+}
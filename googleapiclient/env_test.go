@@ -0,0 +1,75 @@
+package googleapiclient
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"google.golang.org/appengine/urlfetch"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setenv sets the environment variable key to value and returns a function
+// that restores its previous value.
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestNewClientForEnvOffAppEngine(t *testing.T) {
+	// Make sure the App Engine runtime isn't detected.
+	defer setenv("GAE_ENV", "")()
+	defer setenv("GAE_VM", "")()
+
+	// Point Application Default Credentials at a service account key file.
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	creds, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "test@example.iam.gserviceaccount.com",
+		"private_key_id": "test",
+		"private_key":    string(keyPEM),
+		"token_uri":      "https://oauth2.googleapis.com/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "googleapiclient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "credentials.json")
+	if err := ioutil.WriteFile(path, creds, 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer setenv("GOOGLE_APPLICATION_CREDENTIALS", path)()
+
+	client, err := NewClientForEnv(context.Background(), "https://www.googleapis.com/auth/devstorage.read_only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, ok := client.Transport.(*oauth2.Transport)
+	if !ok {
+		t.Fatalf("Expected an oauth2.Transport, got %T", client.Transport)
+	}
+	if _, ok := tr.Base.(*urlfetch.Transport); ok {
+		t.Error("Expected a base transport that doesn't use urlfetch")
+	}
+}
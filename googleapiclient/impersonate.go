@@ -0,0 +1,91 @@
+package googleapiclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/appengine/urlfetch"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// The scope the app's own token needs to call the IAM Service Account
+// Credentials API.
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// NewImpersonatingClient is like NewClient, but requests are authorized as
+// targetServiceAccount (an email address) instead of the app's default
+// service account, using access tokens minted by the IAM Service Account
+// Credentials API. The API must be enabled for the project, and the app's
+// default service account needs the iam.serviceAccounts.getAccessToken
+// permission on targetServiceAccount, which the Service Account Token Creator
+// role (roles/iam.serviceAccountTokenCreator) grants.
+func NewImpersonatingClient(c context.Context, targetServiceAccount string, scopes ...string) *http.Client {
+	base := &urlfetch.Transport{Context: c}
+	return newImpersonatingClient(c, google.AppEngineTokenSource(c, cloudPlatformScope), base, targetServiceAccount, scopes)
+}
+
+func newImpersonatingClient(c context.Context, src oauth2.TokenSource, base http.RoundTripper, target string, scopes []string) *http.Client {
+	ts := &impersonatedTokenSource{
+		c:      c,
+		client: NewClientFromTokenSource(src, base),
+		target: target,
+		scopes: scopes,
+	}
+	return NewClientFromTokenSource(oauth2.ReuseTokenSource(nil, ts), base)
+}
+
+// impersonatedTokenSource mints access tokens for target with the
+// generateAccessToken method of the IAM Service Account Credentials API.
+// https://cloud.google.com/iam/docs/reference/credentials/rest/v1/projects.serviceAccounts/generateAccessToken
+type impersonatedTokenSource struct {
+	c      context.Context
+	client *http.Client
+	target string
+	scopes []string
+}
+
+func (ts *impersonatedTokenSource) Token() (*oauth2.Token, error) {
+	body, err := json.Marshal(struct {
+		Scope []string `json:"scope"`
+	}{ts.scopes})
+	if err != nil {
+		return nil, err
+	}
+	u := "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/" +
+		url.PathEscape(ts.target) + ":generateAccessToken"
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := ts.client.Do(req.WithContext(ts.c))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("googleapiclient: impersonating %v: %v: %s", ts.target, resp.Status, b)
+	}
+	var token struct {
+		AccessToken string    `json:"accessToken"`
+		ExpireTime  time.Time `json:"expireTime"`
+	}
+	if err := json.Unmarshal(b, &token); err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{
+		AccessToken: token.AccessToken,
+		TokenType:   "Bearer",
+		Expiry:      token.ExpireTime,
+	}, nil
+}
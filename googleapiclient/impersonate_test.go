@@ -0,0 +1,72 @@
+package googleapiclient
+
+import (
+	"encoding/json"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// iamTransport fakes the IAM Service Account Credentials API and records
+// every other request.
+type iamTransport struct {
+	t        *testing.T
+	requests []*http.Request
+}
+
+func (tr *iamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := ""
+	if req.URL.Host == "iamcredentials.googleapis.com" {
+		if want := "/v1/projects/-/serviceAccounts/target@example.iam.gserviceaccount.com:generateAccessToken"; req.URL.Path != want {
+			tr.t.Errorf("Expected path %v, got %v", want, req.URL.Path)
+		}
+		if auth := req.Header.Get("Authorization"); auth != "Bearer app-token" {
+			tr.t.Error("Expected the app's token to authorize impersonation, got", auth)
+		}
+		var in struct {
+			Scope []string `json:"scope"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+			tr.t.Error(err)
+		}
+		if len(in.Scope) != 1 || in.Scope[0] != "scope1" {
+			tr.t.Error("Unexpected scopes", in.Scope)
+		}
+		b, _ := json.Marshal(map[string]interface{}{
+			"accessToken": "impersonated-token",
+			"expireTime":  time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+		})
+		body = string(b)
+	} else {
+		tr.requests = append(tr.requests, req)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func TestImpersonatingClient(t *testing.T) {
+	base := &iamTransport{t: t}
+	src := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "app-token"})
+	client := newImpersonatingClient(context.Background(), src, base, "target@example.iam.gserviceaccount.com", []string{"scope1"})
+
+	resp, err := client.Get("https://www.googleapis.com/storage/v1/b/bucket/o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(base.requests) != 1 {
+		t.Fatalf("Expected 1 API request, got %v", len(base.requests))
+	}
+	if auth := base.requests[0].Header.Get("Authorization"); auth != "Bearer impersonated-token" {
+		t.Error("Expected the impersonated token, got", auth)
+	}
+}
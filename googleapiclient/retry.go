@@ -0,0 +1,163 @@
+package googleapiclient
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Defaults used by RetryTransport for fields that are zero.
+const (
+	DefaultMaxAttempts = 4
+	DefaultMinBackoff  = 500 * time.Millisecond
+	DefaultMaxBackoff  = 30 * time.Second
+)
+
+// RetryTransport is an http.RoundTripper that retries idempotent requests
+// that fail with a connection error or a transient status (429, 500, 502,
+// 503, or 504), waiting with exponential backoff between attempts. To retry
+// authorized requests, use it as the base of NewClientWithBase, e.g.,
+//
+//	NewClientWithBase(c, &RetryTransport{Base: &urlfetch.Transport{Context: c}}, scopes...)
+type RetryTransport struct {
+	// Base sends each attempt. If nil, http.DefaultTransport is used.
+	Base http.RoundTripper
+
+	// MaxAttempts is the most times a request is sent, including the first
+	// attempt. If zero, DefaultMaxAttempts is used.
+	MaxAttempts int
+
+	// MinBackoff is the wait before the first retry, which doubles for every
+	// later retry up to MaxBackoff. If a 429 or 503 response has a Retry-After
+	// header, that wait is used instead, still capped at MaxBackoff. If zero,
+	// DefaultMinBackoff and DefaultMaxBackoff are used.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// RoundTrip implements http.RoundTripper. It stops retrying and returns the
+// request context's error if the context is done while waiting.
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !retryable(req) {
+		return t.base().RoundTrip(req)
+	}
+
+	backoff := t.minBackoff()
+	for attempt := 1; ; attempt++ {
+		r := req
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			// A shallow copy, since RoundTrippers must not modify req.
+			clone := *req
+			clone.Body = body
+			r = &clone
+		}
+
+		resp, err := t.base().RoundTrip(r)
+		if attempt >= t.maxAttempts() || !transient(resp, err) {
+			return resp, err
+		}
+
+		wait := backoff
+		if resp != nil {
+			if d, ok := retryAfter(resp, time.Now()); ok {
+				wait = d
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if wait > t.maxBackoff() {
+			wait = t.maxBackoff()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+
+		backoff *= 2
+	}
+}
+
+func (t *RetryTransport) base() http.RoundTripper {
+	if t.Base != nil {
+		return t.Base
+	}
+	return http.DefaultTransport
+}
+
+func (t *RetryTransport) maxAttempts() int {
+	if t.MaxAttempts > 0 {
+		return t.MaxAttempts
+	}
+	return DefaultMaxAttempts
+}
+
+func (t *RetryTransport) minBackoff() time.Duration {
+	if t.MinBackoff > 0 {
+		return t.MinBackoff
+	}
+	return DefaultMinBackoff
+}
+
+func (t *RetryTransport) maxBackoff() time.Duration {
+	if t.MaxBackoff > 0 {
+		return t.MaxBackoff
+	}
+	return DefaultMaxBackoff
+}
+
+// retryable reports whether req is idempotent and its body, if any, can be
+// sent again.
+func retryable(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+	default:
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// transient reports whether an attempt failed in a way that may succeed if
+// tried again.
+func transient(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter returns how long a 429 or 503 response asks the client to wait.
+// Retry-After is either a number of seconds or an HTTP date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
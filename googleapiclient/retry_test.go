@@ -0,0 +1,133 @@
+package googleapiclient
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyTransport fails the first failures requests, alternating between a
+// 503 response and a connection error, then responds with 200 OK.
+type flakyTransport struct {
+	failures int
+	bodies   []string
+}
+
+func (t *flakyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b, _ := ioutil.ReadAll(req.Body)
+	t.bodies = append(t.bodies, string(b))
+	attempt := len(t.bodies)
+	status := http.StatusOK
+	if attempt <= t.failures {
+		if attempt%2 == 0 {
+			return nil, errors.New("connection reset")
+		}
+		status = http.StatusServiceUnavailable
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}, nil
+}
+
+func TestRetryTransport(t *testing.T) {
+	base := &flakyTransport{failures: 2}
+	tr := &RetryTransport{Base: base, MinBackoff: time.Millisecond}
+
+	req, _ := http.NewRequest("PUT", "https://www.googleapis.com/upload", strings.NewReader("data"))
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("Expected 200 OK after retries, got", resp.StatusCode)
+	}
+	if len(base.bodies) != 3 {
+		t.Fatalf("Expected 3 attempts, got %v", len(base.bodies))
+	}
+	for _, b := range base.bodies {
+		if b != "data" {
+			t.Fatalf("Expected the body to be resent on every attempt, got %q", b)
+		}
+	}
+}
+
+func TestRetryTransportMaxAttempts(t *testing.T) {
+	base := &flakyTransport{failures: 5}
+	tr := &RetryTransport{Base: base, MaxAttempts: 3, MinBackoff: time.Millisecond}
+
+	req, _ := http.NewRequest("PUT", "https://www.googleapis.com/upload", strings.NewReader("data"))
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatal("Expected the last failure to be returned, got", resp.StatusCode)
+	}
+	if len(base.bodies) != 3 {
+		t.Fatalf("Expected 3 attempts, got %v", len(base.bodies))
+	}
+}
+
+func TestRetryTransportNotIdempotent(t *testing.T) {
+	base := &flakyTransport{failures: 1}
+	tr := &RetryTransport{Base: base, MinBackoff: time.Millisecond}
+
+	req, _ := http.NewRequest("POST", "https://www.googleapis.com/upload", strings.NewReader("data"))
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || len(base.bodies) != 1 {
+		t.Fatalf("Expected POST to be sent once, got %v attempts", len(base.bodies))
+	}
+}
+
+func TestRetryTransportCanceled(t *testing.T) {
+	base := &flakyTransport{failures: 1}
+	tr := &RetryTransport{Base: base, MinBackoff: time.Hour}
+
+	c, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("PUT", "https://www.googleapis.com/upload", strings.NewReader("data"))
+	_, err := tr.RoundTrip(req.WithContext(c))
+	if err != context.DeadlineExceeded {
+		t.Fatal("Expected context.DeadlineExceeded, got", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		status int
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{http.StatusServiceUnavailable, "120", 2 * time.Minute, true},
+		{http.StatusTooManyRequests, "Fri, 01 Jan 2016 00:00:30 GMT", 30 * time.Second, true},
+		{http.StatusTooManyRequests, "Thu, 31 Dec 2015 00:00:00 GMT", 0, true},
+		{http.StatusTooManyRequests, "", 0, false},
+		{http.StatusInternalServerError, "120", 0, false},
+		{http.StatusServiceUnavailable, "soon", 0, false},
+	}
+	for _, test := range tests {
+		resp := &http.Response{StatusCode: test.status, Header: http.Header{}}
+		if test.header != "" {
+			resp.Header.Set("Retry-After", test.header)
+		}
+		got, ok := retryAfter(resp, now)
+		if got != test.want || ok != test.ok {
+			t.Errorf("retryAfter(%v, %q) = %v, %v; want %v, %v", test.status, test.header, got, ok, test.want, test.ok)
+		}
+	}
+}
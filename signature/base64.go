@@ -0,0 +1,37 @@
+package signature
+
+import (
+	"encoding/base64"
+	"golang.org/x/net/context"
+)
+
+// EncodeBase64 encodes sig as standard, padded base64, the encoding used by
+// SignBase64 and expected by VerifyBase64.
+func EncodeBase64(sig []byte) string {
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// DecodeBase64 decodes a signature encoded by EncodeBase64. Decoding is
+// strict, so each signature has only one valid encoding.
+func DecodeBase64(sigBase64 string) ([]byte, error) {
+	return base64.StdEncoding.Strict().DecodeString(sigBase64)
+}
+
+// SignBase64 is like SignBytes, but returns the signature base64 encoded.
+func SignBase64(c context.Context, data []byte) (keyName string, sigBase64 string, err error) {
+	keyName, sig, err := SignBytes(c, data)
+	if err != nil {
+		return "", "", err
+	}
+	return keyName, EncodeBase64(sig), nil
+}
+
+// VerifyBase64 is like VerifyBytes, but takes a base64 encoded signature as
+// returned by SignBase64.
+func VerifyBase64(c context.Context, data []byte, sigBase64 string) error {
+	sig, err := DecodeBase64(sigBase64)
+	if err != nil {
+		return err
+	}
+	return VerifyBytes(c, data, sig)
+}
@@ -0,0 +1,52 @@
+package signature
+
+import (
+	"bytes"
+	"google.golang.org/appengine/aetest"
+	"testing"
+)
+
+func TestBase64RoundTrip(t *testing.T) {
+	sig := []byte{0xfb, 0xff, 0xfe, 0x00, 0x01}
+	s := EncodeBase64(sig)
+	if s != "+//+AAE=" {
+		t.Fatalf("Expected standard padded base64, got %v", s)
+	}
+	decoded, err := DecodeBase64(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, sig) {
+		t.Fatalf("Expected %v, got %v", sig, decoded)
+	}
+	if _, err := DecodeBase64("-__-AAE"); err == nil {
+		t.Fatal("Expected URL encoded base64 to be rejected")
+	}
+	if _, err := DecodeBase64("+//+AAF="); err == nil {
+		t.Fatal("Expected base64 with non-zero padding bits to be rejected")
+	}
+}
+
+func TestSignAndVerifyBase64(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	data := []byte("hello, world!")
+	_, sig, err := SignBase64(c, data)
+	if err != nil {
+		t.Fatalf("Error signing data. %v", err)
+	}
+
+	if err := VerifyBase64(c, data, sig); err != nil {
+		t.Fatalf("Expected verification to succeed, but it failed. %v", err)
+	}
+	if err := VerifyBase64(c, []byte("tampered"), sig); err == nil {
+		t.Fatal("Expected verification of tampered data to fail, but it succeeded")
+	}
+	if err := VerifyBase64(c, data, "not base64!"); err == nil {
+		t.Fatal("Expected verification of malformed base64 to fail, but it succeeded")
+	}
+}
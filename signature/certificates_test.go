@@ -0,0 +1,99 @@
+package signature
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// newCertificate generates an RSA key pair and a self-signed certificate for it.
+func newCertificate(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, cert
+}
+
+func TestVerifyWithCertificates(t *testing.T) {
+	key, cert := newCertificate(t)
+	_, otherCert := newCertificate(t)
+
+	data := []byte("hello, world!")
+	hashed := sha256.Sum256(data)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyWithCertificates([]*x509.Certificate{cert}, data, sig, crypto.SHA256); err != nil {
+		t.Fatalf("Expected verification to succeed, but it failed. %v", err)
+	}
+	if err := VerifyWithCertificates([]*x509.Certificate{otherCert, cert}, data, sig, crypto.SHA256); err != nil {
+		t.Fatalf("Expected verification with any matching certificate to succeed, but it failed. %v", err)
+	}
+	if err := VerifyWithCertificates([]*x509.Certificate{otherCert}, data, sig, crypto.SHA256); err == nil {
+		t.Fatal("Expected verification with the wrong certificate to fail, but it succeeded")
+	}
+	if err := VerifyWithCertificates([]*x509.Certificate{cert}, []byte("tampered"), sig, crypto.SHA256); err == nil {
+		t.Fatal("Expected verification of tampered data to fail, but it succeeded")
+	}
+	if err := VerifyWithCertificates([]*x509.Certificate{cert}, data, sig, crypto.SHA512); err == nil {
+		t.Fatal("Expected verification with the wrong hash to fail, but it succeeded")
+	}
+	if err := VerifyWithCertificates(nil, data, sig, crypto.SHA256); err != ErrNoPublicCertificates {
+		t.Fatalf("Expected ErrNoPublicCertificates without certificates, got %v", err)
+	}
+}
+
+func TestVerifyBytesPEM(t *testing.T) {
+	key, cert := newCertificate(t)
+	_, otherCert := newCertificate(t)
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	otherPEMData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: otherCert.Raw})
+
+	data := []byte("hello, world!")
+	hashed := sha256.Sum256(data)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := VerifyBytesPEM(pemData, data, sig, crypto.SHA256); err != nil {
+		t.Fatalf("Expected verification to succeed, but it failed. %v", err)
+	}
+	if err := VerifyBytesPEM(otherPEMData, data, sig, crypto.SHA256); err == nil {
+		t.Fatal("Expected verification with the wrong certificate to fail, but it succeeded")
+	}
+	if err := VerifyBytesPEM(pemData, []byte("tampered"), sig, crypto.SHA256); err == nil {
+		t.Fatal("Expected verification of tampered data to fail, but it succeeded")
+	}
+	if err := VerifyBytesPEM([]byte("not a certificate"), data, sig, crypto.SHA256); err != ErrPemDecodeFailure {
+		t.Fatalf("Expected ErrPemDecodeFailure, got %v", err)
+	}
+	garbled := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("garbage")})
+	if err := VerifyBytesPEM(garbled, data, sig, crypto.SHA256); err == nil {
+		t.Fatal("Expected verification with an unparseable certificate to fail, but it succeeded")
+	}
+}
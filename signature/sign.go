@@ -0,0 +1,15 @@
+package signature
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+)
+
+// SignBytes signs bytes with the application's service account private key,
+// producing an RSA PKCS #1 v1.5 signature of the SHA-256 digest that
+// VerifyBytes verifies. keyName identifies the key used, for
+// VerifyBytesWithKeyName. c must be a context.Context created from
+// appengine.NewContext.
+func SignBytes(c context.Context, bytes []byte) (keyName string, sig []byte, err error) {
+	return appengine.SignBytes(c, bytes)
+}
@@ -0,0 +1,30 @@
+package signature
+
+import (
+	"google.golang.org/appengine/aetest"
+	"testing"
+)
+
+func TestSignBytesRoundTrip(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	data := []byte("hello, world!")
+	keyName, sig, err := SignBytes(c, data)
+	if err != nil {
+		t.Fatalf("Error signing data. %v", err)
+	}
+
+	if err := VerifyBytes(c, data, sig); err != nil {
+		t.Fatalf("Expected verification to succeed, but it failed. %v", err)
+	}
+	if err := VerifyBytesWithKeyName(c, data, sig, keyName); err != nil {
+		t.Fatalf("Expected verification with key name %v to succeed, but it failed. %v", keyName, err)
+	}
+	if err := VerifyBytes(c, []byte("tampered"), sig); err == nil {
+		t.Fatal("Expected verification of tampered data to fail, but it succeeded")
+	}
+}
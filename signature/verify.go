@@ -1,5 +1,5 @@
-// Package signature verifies signatures created by the App Engine runtime's
-// appengine.SignBytes function.
+// Package signature signs bytes with, and verifies signatures created by, the
+// App Engine runtime's appengine.SignBytes function.
 package signature
 
 import (
@@ -17,40 +17,107 @@ var (
 	ErrNoPublicCertificates = errors.New("ErrNoPublicCertificates")
 	ErrPemDecodeFailure     = errors.New("ErrPemDecodeFailure")
 	ErrNotRSAPublicKey      = errors.New("ErrNotRSAPublicKey")
+	ErrKeyNotFound          = errors.New("ErrKeyNotFound")
+	ErrHashUnavailable      = errors.New("ErrHashUnavailable")
 )
 
+// ContextError is returned when the context is done before the public
+// certificates have been fetched. Err is the context's error, such as
+// context.DeadlineExceeded.
+type ContextError struct {
+	Err error
+}
+
+func (e *ContextError) Error() string {
+	return "signature: fetching public certificates: " + e.Err.Error()
+}
+
+// Unwrap returns Err, so errors.Is(err, context.Canceled) works with Go 1.13
+// and later.
+func (e *ContextError) Unwrap() error {
+	return e.Err
+}
+
+// The hash used by appengine.SignBytes.
+const signBytesHash = crypto.SHA256
+
 // VerifyBytes verifies a signature produced by appengine.SignBytes. c must be a
-// context.Context created from appengine.NewContext.
+// context.Context created from appengine.NewContext. Fetching the public
+// certificates is bounded by c's deadline, so callers that can't afford to
+// block on a slow certificate API should pass a context.WithTimeout. If c is
+// done first, a ContextError is returned.
 func VerifyBytes(c context.Context, bytes []byte, sig []byte) error {
-	certs, err := appengine.PublicCertificates(c)
+	_, err := VerifyBytesReturningKey(c, bytes, sig)
+	return err
+}
+
+// VerifyBytesReturningKey is like VerifyBytes, but also returns the key name
+// of the public certificate that verified the signature, which is useful for
+// auditing and monitoring key rotation.
+func VerifyBytesReturningKey(c context.Context, bytes []byte, sig []byte) (string, error) {
+	return verifyBytes(c, bytes, sig, signBytesHash)
+}
+
+// VerifyBytesWithHash is like VerifyBytes, but verifies an RSA PKCS #1 v1.5
+// signature of the given hash of bytes instead of the SHA-256 hash used by
+// appengine.SignBytes, for signatures produced elsewhere.
+func VerifyBytesWithHash(c context.Context, bytes []byte, sig []byte, hash crypto.Hash) error {
+	_, err := verifyBytes(c, bytes, sig, hash)
+	return err
+}
+
+func verifyBytes(c context.Context, bytes []byte, sig []byte, hash crypto.Hash) (string, error) {
+	if !hash.Available() {
+		return "", ErrHashUnavailable
+	}
+
+	certs, err := publicCertificates(c)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	lastErr := ErrNoPublicCertificates
 
-	signBytesHash := crypto.SHA256
-	h := signBytesHash.New()
-	h.Write(bytes)
-	hashed := h.Sum(nil)
-
 	for _, cert := range certs {
-		block, _ := pem.Decode(cert.Data)
-		if block == nil {
-			lastErr = ErrPemDecodeFailure
+		x509Cert, err := parseCertificate(cert.Data)
+		if err != nil {
+			lastErr = err
 			continue
 		}
-		x509Cert, err := x509.ParseCertificate(block.Bytes)
+
+		err = VerifyWithCertificates([]*x509.Certificate{x509Cert}, bytes, sig, hash)
 		if err != nil {
 			lastErr = err
 			continue
 		}
-		pubkey, ok := x509Cert.PublicKey.(*rsa.PublicKey)
+
+		return cert.KeyName, nil
+	}
+
+	return "", lastErr
+}
+
+// VerifyWithCertificates verifies an RSA PKCS #1 v1.5 signature of the given
+// hash of bytes against certs, succeeding if any certificate verifies it. It
+// does not need an App Engine context, so it can be used to verify signatures
+// offline with certificates fetched out of band.
+func VerifyWithCertificates(certs []*x509.Certificate, bytes []byte, sig []byte, hash crypto.Hash) error {
+	if !hash.Available() {
+		return ErrHashUnavailable
+	}
+
+	lastErr := ErrNoPublicCertificates
+
+	hashed := digest(hash, bytes)
+
+	for _, cert := range certs {
+		pubkey, ok := cert.PublicKey.(*rsa.PublicKey)
 		if !ok {
 			lastErr = ErrNotRSAPublicKey
 			continue
 		}
-		err = rsa.VerifyPKCS1v15(pubkey, signBytesHash, hashed, sig)
+
+		err := rsa.VerifyPKCS1v15(pubkey, hash, hashed, sig)
 		if err != nil {
 			lastErr = err
 			continue
@@ -61,3 +128,78 @@ func VerifyBytes(c context.Context, bytes []byte, sig []byte) error {
 
 	return lastErr
 }
+
+// VerifyBytesPEM is like VerifyWithCertificates, but verifies against the
+// single PEM encoded certificate in pemData, such as one of the certificates
+// returned by appengine.PublicCertificates. ErrPemDecodeFailure is returned if
+// pemData doesn't contain a PEM block.
+func VerifyBytesPEM(pemData []byte, bytes []byte, sig []byte, hash crypto.Hash) error {
+	cert, err := parseCertificate(pemData)
+	if err != nil {
+		return err
+	}
+	return VerifyWithCertificates([]*x509.Certificate{cert}, bytes, sig, hash)
+}
+
+// VerifyBytesWithKeyName is like VerifyBytes, but only verifies against the
+// public certificate named keyName, which is the key name returned by
+// appengine.SignBytes. ErrKeyNotFound is returned if there is no such certificate,
+// for instance because the key has been rotated out.
+func VerifyBytesWithKeyName(c context.Context, bytes []byte, sig []byte, keyName string) error {
+	certs, err := publicCertificates(c)
+	if err != nil {
+		return err
+	}
+
+	for _, cert := range certs {
+		if cert.KeyName == keyName {
+			x509Cert, err := parseCertificate(cert.Data)
+			if err != nil {
+				return err
+			}
+			return VerifyWithCertificates([]*x509.Certificate{x509Cert}, bytes, sig, signBytesHash)
+		}
+	}
+
+	return ErrKeyNotFound
+}
+
+// publicCertificates fetches the App Engine public certificates, giving up
+// with a ContextError if c is done first.
+func publicCertificates(c context.Context) ([]appengine.Certificate, error) {
+	if err := c.Err(); err != nil {
+		return nil, &ContextError{Err: err}
+	}
+
+	type result struct {
+		certs []appengine.Certificate
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		certs, err := appengine.PublicCertificates(c)
+		done <- result{certs, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.certs, r.err
+	case <-c.Done():
+		return nil, &ContextError{Err: c.Err()}
+	}
+}
+
+func digest(hash crypto.Hash, bytes []byte) []byte {
+	h := hash.New()
+	h.Write(bytes)
+	return h.Sum(nil)
+}
+
+// parseCertificate parses the PEM encoded certificate in data.
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrPemDecodeFailure
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
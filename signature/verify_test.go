@@ -1,9 +1,12 @@
 package signature
 
 import (
+	"crypto"
+	"golang.org/x/net/context"
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/aetest"
 	"testing"
+	"time"
 )
 
 func TestSignatureVerification(t *testing.T) {
@@ -28,3 +31,91 @@ func TestSignatureVerification(t *testing.T) {
 		t.Fatalf("Expected verification to fail, but if succeeded")
 	}
 }
+
+func TestSignatureVerificationWithKeyName(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	data := []byte("hello, world!")
+	keyName, sig, err := appengine.SignBytes(c, data)
+	if err != nil {
+		t.Fatalf("Error signing data. %v", err)
+	}
+
+	if err := VerifyBytesWithKeyName(c, data, sig, keyName); err != nil {
+		t.Fatalf("Expected verification to succeed, but it failed. %v", err)
+	}
+
+	if err := VerifyBytesWithKeyName(c, []byte("hello, world!!"), sig, keyName); err == nil {
+		t.Fatal("Expected verification of different data to fail, but it succeeded")
+	}
+
+	if err := VerifyBytesWithKeyName(c, data, sig, "no-such-key"); err != ErrKeyNotFound {
+		t.Fatalf("Expected ErrKeyNotFound but got %v", err)
+	}
+}
+
+func TestSignatureVerificationReturningKey(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	data := []byte("hello, world!")
+	signedKeyName, sig, err := appengine.SignBytes(c, data)
+	if err != nil {
+		t.Fatalf("Error signing data. %v", err)
+	}
+
+	keyName, err := VerifyBytesReturningKey(c, data, sig)
+	if err != nil {
+		t.Fatalf("Expected verification to succeed, but it failed. %v", err)
+	}
+	if keyName == "" || keyName != signedKeyName {
+		t.Fatalf("Expected key name %q but got %q", signedKeyName, keyName)
+	}
+}
+
+func TestSignatureVerificationWithHash(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	data := []byte("hello, world!")
+	_, sig, err := appengine.SignBytes(c, data)
+	if err != nil {
+		t.Fatalf("Error signing data. %v", err)
+	}
+
+	if err := VerifyBytesWithHash(c, data, sig, crypto.SHA256); err != nil {
+		t.Fatalf("Expected verification with SHA-256 to succeed, but it failed. %v", err)
+	}
+	if err := VerifyBytesWithHash(c, data, sig, crypto.SHA512); err == nil {
+		t.Fatal("Expected verification with SHA-512 to fail, but it succeeded")
+	}
+}
+
+func TestSignatureVerificationCanceled(t *testing.T) {
+	c, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- VerifyBytes(c, []byte("hello, world!"), []byte("signature"))
+	}()
+
+	select {
+	case err := <-done:
+		if cerr, ok := err.(*ContextError); !ok || cerr.Err != context.Canceled {
+			t.Fatalf("Expected a ContextError wrapping context.Canceled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected verification with a canceled context to return promptly")
+	}
+}
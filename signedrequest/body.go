@@ -0,0 +1,83 @@
+package signedrequest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"golang.org/x/net/context"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// HashingReader computes the SHA-256 digest of everything read through it,
+// so a body can be hashed while it's streamed instead of buffered. For
+// instance, a server that copies a large upload elsewhere can wrap r.Body,
+// copy it, and then compare Sum with BodyHash, rather than calling
+// VerifyBody, which holds the whole body in memory. The content must not be
+// trusted until the digest has been checked.
+type HashingReader struct {
+	r io.Reader
+	h hash.Hash
+}
+
+// NewHashingReader returns a HashingReader that reads from r.
+func NewHashingReader(r io.Reader) *HashingReader {
+	return &HashingReader{r: r, h: sha256.New()}
+}
+
+func (hr *HashingReader) Read(b []byte) (int, error) {
+	n, err := hr.r.Read(b)
+	hr.h.Write(b[:n])
+	return n, err
+}
+
+// Sum returns the hex encoded SHA-256 digest of the content read so far, in
+// the same form as BodyHash.
+func (hr *HashingReader) Sum() string {
+	return hex.EncodeToString(hr.h.Sum(nil))
+}
+
+// SetBodyHash sets BodyHash to the digest of body and returns a reader that
+// produces the same content, to send as the request body. Because BodyHash is
+// signed, call SetBodyHash before Sign. If body is an io.Seeker, it's read
+// once to hash it and then rewound, so the content isn't held in memory.
+// Otherwise the entire body is buffered, as with HashBody.
+func (p *SignedRequest) SetBodyHash(body io.Reader) (io.Reader, error) {
+	if s, ok := body.(io.Seeker); ok {
+		start, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		hr := NewHashingReader(body)
+		if _, err := io.Copy(ioutil.Discard, hr); err != nil {
+			return nil, err
+		}
+		if _, err := s.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		p.BodyHash = hr.Sum()
+		return body, nil
+	}
+	hash, buffered, err := HashBody(body)
+	if err != nil {
+		return nil, err
+	}
+	p.BodyHash = hash
+	return buffered, nil
+}
+
+// HTTPRequestWithBodyHash sets BodyHash from body with SetBodyHash, signs p
+// with Sign, and returns an HTTP request that sends body. c is passed to
+// Sign. To sign with a custom Signer, call SetBodyHash, Signer.Sign, and
+// HTTPRequest instead.
+func (p *SignedRequest) HTTPRequestWithBodyHash(c context.Context, body io.Reader) (*http.Request, error) {
+	body, err := p.SetBodyHash(body)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Sign(c); err != nil {
+		return nil, err
+	}
+	return p.HTTPRequest(body)
+}
@@ -0,0 +1,103 @@
+package signedrequest
+
+import (
+	"bytes"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/aetest"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	helloWorld     = "hello, world!"
+	helloWorldHash = "68e656b251e67e8358bef8483ab0d51c6619f3e7a1a9f0e75838d41ff368f728"
+)
+
+func TestHashingReader(t *testing.T) {
+	hr := NewHashingReader(strings.NewReader(helloWorld))
+	b, err := ioutil.ReadAll(hr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != helloWorld {
+		t.Fatalf("Expected content to pass through unchanged. Got %v", string(b))
+	}
+	if hr.Sum() != helloWorldHash {
+		t.Fatalf("Expected hash %v but got %v", helloWorldHash, hr.Sum())
+	}
+}
+
+func TestSetBodyHash(t *testing.T) {
+	// A seekable body is rewound rather than buffered.
+	seekable := strings.NewReader(helloWorld)
+	p := &SignedRequest{Method: "PUT", URL: "https://example.com/upload", Expiration: time.Now().Add(time.Minute)}
+	body, err := p.SetBodyHash(seekable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.BodyHash != helloWorldHash {
+		t.Fatalf("Expected hash %v but got %v", helloWorldHash, p.BodyHash)
+	}
+	if body != seekable {
+		t.Fatal("Expected the seekable body to be returned")
+	}
+
+	// Anything else is buffered.
+	p.BodyHash = ""
+	body, err = p.SetBodyHash(ioutil.NopCloser(bytes.NewBufferString(helloWorld)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.BodyHash != helloWorldHash {
+		t.Fatalf("Expected hash %v but got %v", helloWorldHash, p.BodyHash)
+	}
+
+	c := context.Background()
+	key := newRSAKey(t, "key1")
+	if err := (&Signer{Key: key}).Sign(c, p); err != nil {
+		t.Fatal(err)
+	}
+	req, err := p.HTTPRequest(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := ParseHTTPRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Verifier{Key: key}).Verify(c, p2); err != nil {
+		t.Fatalf("Expected signed request to verify. %v", err)
+	}
+	if err := p2.VerifyBody(req); err != nil {
+		t.Fatalf("Expected body to verify. %v", err)
+	}
+}
+
+func TestHTTPRequestWithBodyHash(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	p := &SignedRequest{Method: "PUT", URL: "https://example.com/upload", Expiration: time.Now().Add(time.Minute)}
+	req, err := p.HTTPRequestWithBodyHash(c, strings.NewReader(helloWorld))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2, err := ParseHTTPRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p2.BodyHash != helloWorldHash {
+		t.Fatalf("Expected hash %v but got %v", helloWorldHash, p2.BodyHash)
+	}
+	if err := p2.Verify(c); err != nil {
+		t.Fatalf("Expected signed request to verify. %v", err)
+	}
+	if err := p2.VerifyBody(req); err != nil {
+		t.Fatalf("Expected body to verify. %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package signedrequest
+
+import (
+	"errors"
+	"google.golang.org/appengine"
+	"net"
+	"net/http"
+)
+
+// Error code that indicates the request was sent by a client other than the
+// one named by BoundTo.
+var ErrBoundToMismatch = errors.New("ErrBoundToMismatch")
+
+// VerifyBoundTo checks that r was sent by the client BoundTo names: either
+// from an IP address it matches, or, if BoundTo isn't an IP address or CIDR,
+// by subject, the authenticated subject of r. If BoundTo is empty, r isn't
+// checked.
+func (p *SignedRequest) VerifyBoundTo(r *http.Request, subject string) error {
+	if p.BoundTo == "" {
+		return nil
+	}
+	if _, network, err := net.ParseCIDR(p.BoundTo); err == nil {
+		if ip := clientIP(r); ip != nil && network.Contains(ip) {
+			return nil
+		}
+		return ErrBoundToMismatch
+	}
+	if bound := net.ParseIP(p.BoundTo); bound != nil {
+		if ip := clientIP(r); ip != nil && bound.Equal(ip) {
+			return nil
+		}
+		return ErrBoundToMismatch
+	}
+	if subject == "" || subject != p.BoundTo {
+		return ErrBoundToMismatch
+	}
+	return nil
+}
+
+// clientIP returns the IP address of the client that sent r, or nil if it
+// can't be determined. On App Engine, requests arrive through Google's front
+// end, which sets X-Appengine-User-Ip to the client's address and strips any
+// value the client sent, so that is used if present. X-Forwarded-For isn't
+// used: the front end appends to whatever the client sent, so its leading
+// entries can be forged. Otherwise, r.RemoteAddr is used.
+func clientIP(r *http.Request) net.IP {
+	if appengine.IsAppEngine() {
+		if ip := net.ParseIP(r.Header.Get("X-Appengine-User-Ip")); ip != nil {
+			return ip
+		}
+	}
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,150 @@
+package signedrequest
+
+import (
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestVerifyBoundTo(t *testing.T) {
+	tests := []struct {
+		boundTo    string
+		remoteAddr string
+		subject    string
+		err        error
+	}{
+		{"", "198.51.100.1:1234", "", nil},
+		{"203.0.113.7", "203.0.113.7:1234", "", nil},
+		{"203.0.113.7", "203.0.113.8:1234", "", ErrBoundToMismatch},
+		{"203.0.113.0/24", "203.0.113.200:1234", "", nil},
+		{"203.0.113.0/24", "198.51.100.1:1234", "", ErrBoundToMismatch},
+		{"2001:db8::1", "[2001:db8::1]:1234", "", nil},
+		{"2001:db8::/32", "[2001:db9::1]:1234", "", ErrBoundToMismatch},
+		{"203.0.113.7", "not an address", "", ErrBoundToMismatch},
+		{"user-1", "203.0.113.7:1234", "user-1", nil},
+		{"user-1", "203.0.113.7:1234", "user-2", ErrBoundToMismatch},
+		{"user-1", "203.0.113.7:1234", "", ErrBoundToMismatch},
+		{"203.0.113.7", "198.51.100.1:1234", "203.0.113.7", ErrBoundToMismatch},
+	}
+	for _, test := range tests {
+		p := &SignedRequest{BoundTo: test.boundTo}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if err := p.VerifyBoundTo(r, test.subject); err != test.err {
+			t.Errorf("BoundTo %q from %v as %q: expected %v but got %v", test.boundTo, test.remoteAddr, test.subject, test.err, err)
+		}
+	}
+}
+
+// setenv sets the environment variable key to value and returns a function
+// that restores its previous value.
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.2")
+	r.Header.Set("X-Appengine-User-Ip", "198.51.100.1")
+
+	// Off App Engine, both headers could be set by the client, so they are
+	// ignored.
+	restore := setenv("GAE_ENV", "")
+	if ip := clientIP(r); ip.String() != "10.0.0.1" {
+		t.Errorf("Expected the remote address, got %v", ip)
+	}
+	restore()
+
+	defer setenv("GAE_ENV", "standard")()
+	if ip := clientIP(r); ip.String() != "198.51.100.1" {
+		t.Errorf("Expected X-Appengine-User-Ip, got %v", ip)
+	}
+
+	// X-Forwarded-For can be forged, so it is never used.
+	r.Header.Del("X-Appengine-User-Ip")
+	if ip := clientIP(r); ip.String() != "10.0.0.1" {
+		t.Errorf("Expected the remote address instead of X-Forwarded-For, got %v", ip)
+	}
+}
+
+func TestHandlerBoundTo(t *testing.T) {
+	key := newRSAKey(t, "test-key")
+	handler := &Handler{
+		Func: func(w http.ResponseWriter, r *http.Request, sr *SignedRequest) {
+			w.WriteHeader(http.StatusOK)
+		},
+		Verifier: &Verifier{Key: key},
+		Subject: func(r *http.Request) string {
+			return r.Header.Get("X-Test-User")
+		},
+	}
+
+	tests := []struct {
+		boundTo    string
+		remoteAddr string
+		user       string
+		code       int
+	}{
+		{"203.0.113.7", "203.0.113.7:1234", "", http.StatusOK},
+		{"203.0.113.7", "198.51.100.1:1234", "", http.StatusForbidden},
+		{"203.0.113.0/24", "203.0.113.9:1234", "", http.StatusOK},
+		{"user-1", "198.51.100.1:1234", "user-1", http.StatusOK},
+		{"user-1", "198.51.100.1:1234", "user-2", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		sr := &SignedRequest{
+			Method:     "GET",
+			URL:        "/download",
+			Expiration: time.Now().Add(time.Minute),
+			BoundTo:    test.boundTo,
+		}
+		if err := (&Signer{Key: key}).Sign(context.Background(), sr); err != nil {
+			t.Fatalf("Error signing %v", err)
+		}
+		req, err := sr.HTTPRequest(nil)
+		if err != nil {
+			t.Fatalf("Failed to get request %v", err)
+		}
+		req.RemoteAddr = test.remoteAddr
+		req.Header.Set("X-Test-User", test.user)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != test.code {
+			t.Errorf("BoundTo %q from %v as %q: expected %v, got %v", test.boundTo, test.remoteAddr, test.user, test.code, rr.Code)
+		}
+	}
+
+	// BoundTo is signed, so it can't be changed to another client.
+	sr := &SignedRequest{
+		Method:     "GET",
+		URL:        "/download",
+		Expiration: time.Now().Add(time.Minute),
+		BoundTo:    "203.0.113.7",
+	}
+	if err := (&Signer{Key: key}).Sign(context.Background(), sr); err != nil {
+		t.Fatalf("Error signing %v", err)
+	}
+	req, err := sr.HTTPRequest(nil)
+	if err != nil {
+		t.Fatalf("Failed to get request %v", err)
+	}
+	req.Header.Set("Signature-Bound-To", "198.51.100.1")
+	req.RemoteAddr = "198.51.100.1:1234"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a changed BoundTo to be rejected with %v, got %v", http.StatusUnauthorized, rr.Code)
+	}
+}
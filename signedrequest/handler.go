@@ -1,8 +1,16 @@
 package signedrequest
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"golang.org/x/net/context"
 	"google.golang.org/appengine"
+	"google.golang.org/appengine/log"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
 // HandlerFunc is like http.HandlerFunc, but also takes SignedRequest
@@ -13,23 +21,274 @@ type HandlerFunc func(http.ResponseWriter, *http.Request, *SignedRequest)
 // ServeHTTP implements the http.Handler interface. If the request signature is valid, the
 // HandlerFunc is invoked.
 func (f HandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h := &Handler{Func: f}
+	h.ServeHTTP(w, r)
+}
+
+// NonceStore records the nonces of accepted signed requests so they
+// can't be replayed. It can be backed by memcache, with entries expiring
+// at the expiry passed to CheckAndStore.
+type NonceStore interface {
+	// CheckAndStore returns true if nonce has not been seen before, in which
+	// case it is recorded until expiry. It returns false if nonce has already
+	// been used.
+	CheckAndStore(c context.Context, nonce string, expiry time.Time) (bool, error)
+}
+
+// SeenCache remembers accepted signed requests until they expire, so they
+// can't be replayed. Implementations must be safe for concurrent use.
+type SeenCache interface {
+	// CheckAndAdd returns true if key hasn't been added, or has expired, in
+	// which case it is recorded until exp. It returns false if key has
+	// already been added. The check and the add must be atomic, so that
+	// concurrent replays can't both succeed.
+	CheckAndAdd(key string, exp time.Time) bool
+}
+
+// MemorySeenCache is an in-memory SeenCache. Expired keys are evicted
+// whenever the cache doubles in size, so its size is bounded by twice the
+// number of requests accepted within their lifetimes. On App Engine, each
+// instance has its own memory, so a request can still be replayed once per
+// instance; use a NonceStore backed by memcache or the datastore to prevent that.
+type MemorySeenCache struct {
+	mu      sync.Mutex
+	seen    map[string]time.Time
+	now     func() time.Time
+	evictAt int
+}
+
+// The smallest size at which MemorySeenCache evicts expired keys.
+const minEvictSize = 64
+
+// NewMemorySeenCache returns an empty MemorySeenCache.
+func NewMemorySeenCache() *MemorySeenCache {
+	return &MemorySeenCache{seen: make(map[string]time.Time), now: time.Now, evictAt: minEvictSize}
+}
+
+// CheckAndAdd implements SeenCache.
+func (m *MemorySeenCache) CheckAndAdd(key string, exp time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	if e, ok := m.seen[key]; ok && !now.After(e) {
+		return false
+	}
+	m.seen[key] = exp
+	if len(m.seen) >= m.evictAt {
+		for k, e := range m.seen {
+			if now.After(e) {
+				delete(m.seen, k)
+			}
+		}
+		m.evictAt = 2 * len(m.seen)
+		if m.evictAt < minEvictSize {
+			m.evictAt = minEvictSize
+		}
+	}
+	return true
+}
+
+// Len returns the number of signatures in the cache, including any expired
+// ones that haven't been evicted yet.
+func (m *MemorySeenCache) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.seen)
+}
+
+// Handler is an http.Handler like HandlerFunc, but with configurable
+// verification. Func is only called if the signature is valid.
+type Handler struct {
+	Func HandlerFunc
+
+	// Verifier verifies request signatures. If nil, a zero Verifier is used.
+	Verifier *Verifier
+
+	// NonceStore is optional. If set, requests without a Nonce or with a
+	// Nonce that has already been used are rejected.
+	NonceStore NonceStore
+
+	// AllowedMethods and AllowedHosts are optional allowlists. If set,
+	// requests with any other method or Host are rejected before their
+	// signature is verified, so junk traffic doesn't cost an RSA
+	// verification. Hosts match with or without a port, ignoring case.
+	AllowedMethods []string
+	AllowedHosts   []string
+
+	// SeenCache is optional. If set, requests whose signed content has
+	// already been accepted are rejected, which blocks simple replays without
+	// requiring a Nonce.
+	SeenCache SeenCache
+
+	// Subject is optional. It returns the authenticated subject of r, such as
+	// a user ID, which must match the BoundTo of requests bound to a subject
+	// rather than an IP address. If nil, such requests are rejected.
+	Subject func(r *http.Request) string
+
+	// Logger is optional. If set, rejected requests are logged as warnings,
+	// verification failures as errors, and accepted requests as debug
+	// messages. Use AppEngineLogger to log with google.golang.org/appengine/log.
+	Logger Logger
+}
+
+// Logger receives a Handler's log messages.
+type Logger interface {
+	Debugf(c context.Context, format string, args ...interface{})
+	Warningf(c context.Context, format string, args ...interface{})
+	Errorf(c context.Context, format string, args ...interface{})
+}
+
+// AppEngineLogger is a Logger that logs with google.golang.org/appengine/log.
+type AppEngineLogger struct{}
+
+// Debugf implements Logger.
+func (AppEngineLogger) Debugf(c context.Context, format string, args ...interface{}) {
+	log.Debugf(c, format, args...)
+}
+
+// Warningf implements Logger.
+func (AppEngineLogger) Warningf(c context.Context, format string, args ...interface{}) {
+	log.Warningf(c, format, args...)
+}
+
+// Errorf implements Logger.
+func (AppEngineLogger) Errorf(c context.Context, format string, args ...interface{}) {
+	log.Errorf(c, format, args...)
+}
+
+// ServeHTTP implements the http.Handler interface. If the request signature is valid, the
+// Func is invoked.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	c := appengine.NewContext(r)
-	signedRequest, err := ParseHTTPRequest(r)
+	if !h.allowed(r) {
+		h.reject(c, w, r, http.StatusBadRequest, "Request not allowed.", "method or host not allowed")
+		return
+	}
+	var signedRequest *SignedRequest
+	var err error
+	if isSignedURL(r) {
+		signedRequest, err = parseSignedURL(r)
+	} else {
+		signedRequest, err = ParseHTTPRequest(r)
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Not a valid signed request."))
+		h.reject(c, w, r, http.StatusBadRequest, "Not a valid signed request.", err)
+		return
+	}
+	v := h.Verifier
+	if v == nil {
+		v = &Verifier{}
+	}
+	err = v.Verify(c, signedRequest)
+	switch err {
+	case nil:
+	case ErrExpired:
+		h.reject(c, w, r, http.StatusBadRequest, "Signed URL expired.", err)
+		return
+	case ErrMalformedSignature, ErrUnknownAlgorithm, ErrMissingRequiredHeader, ErrExpirationTooFar:
+		h.reject(c, w, r, http.StatusBadRequest, "Not a valid signed request.", err)
+		return
+	case ErrBadSignature, ErrInsufficientSignatures:
+		h.reject(c, w, r, http.StatusUnauthorized, "Invalid signature.", err)
+		return
+	default:
+		h.fail(c, w, r, err)
 		return
 	}
-	err = signedRequest.Verify(c)
+	err = signedRequest.VerifyBody(r)
 	if err != nil {
-		if err == ErrExpired {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte("Signed URL expired."))
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
+		h.reject(c, w, r, http.StatusBadRequest, "Body does not match signed request.", err)
 		return
 	}
+	var subject string
+	if h.Subject != nil {
+		subject = h.Subject(r)
+	}
+	err = signedRequest.VerifyBoundTo(r, subject)
+	if err != nil {
+		h.reject(c, w, r, http.StatusForbidden, "Signed request not valid for this client.", err)
+		return
+	}
+	if h.NonceStore != nil {
+		if signedRequest.Nonce == "" {
+			h.reject(c, w, r, http.StatusBadRequest, "Signed request nonce required.", "missing nonce")
+			return
+		}
+		fresh, err := h.NonceStore.CheckAndStore(c, signedRequest.Nonce, signedRequest.Expiration.Add(v.Leeway))
+		if err != nil {
+			h.fail(c, w, r, err)
+			return
+		}
+		if !fresh {
+			h.reject(c, w, r, http.StatusBadRequest, "Signed request already used.", "nonce already used")
+			return
+		}
+	}
+
+	if h.SeenCache != nil {
+		if !h.SeenCache.CheckAndAdd(seenKey(signedRequest), signedRequest.Expiration.Add(v.Leeway)) {
+			h.reject(c, w, r, http.StatusBadRequest, "Signed request already used.", "signed request already seen")
+			return
+		}
+	}
 
-	f(w, r, signedRequest)
+	if h.Logger != nil {
+		h.Logger.Debugf(c, "signedrequest: verified %v %v signed by key %q", r.Method, r.URL, signedRequest.KeyName)
+	}
+	h.Func(w, r, signedRequest)
+}
+
+// seenKey identifies p in a SeenCache. It is derived from what was signed
+// rather than the signature, which can be encoded more than one way and is
+// empty for requests with only co-signatures.
+func seenKey(p *SignedRequest) string {
+	sum := sha256.Sum256([]byte(p.signingString()))
+	return hex.EncodeToString(sum[:])
+}
+
+// reject responds with code and message, and logs reason as a warning.
+func (h *Handler) reject(c context.Context, w http.ResponseWriter, r *http.Request, code int, message string, reason interface{}) {
+	if h.Logger != nil {
+		h.Logger.Warningf(c, "signedrequest: rejected %v %v with %v: %v", r.Method, r.URL, code, reason)
+	}
+	w.WriteHeader(code)
+	w.Write([]byte(message))
+}
+
+// fail responds with an internal server error and logs err as an error.
+func (h *Handler) fail(c context.Context, w http.ResponseWriter, r *http.Request, err error) {
+	if h.Logger != nil {
+		h.Logger.Errorf(c, "signedrequest: failed to verify %v %v: %v", r.Method, r.URL, err)
+	}
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+// allowed reports whether r passes the AllowedMethods and AllowedHosts
+// allowlists.
+func (h *Handler) allowed(r *http.Request) bool {
+	if len(h.AllowedMethods) > 0 && !contains(h.AllowedMethods, r.Method) {
+		return false
+	}
+	if len(h.AllowedHosts) > 0 {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		for _, allowed := range h.AllowedHosts {
+			if strings.EqualFold(allowed, r.Host) || strings.EqualFold(allowed, host) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
 }
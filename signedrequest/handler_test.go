@@ -1,10 +1,14 @@
 package signedrequest
 
 import (
+	"fmt"
+	"golang.org/x/net/context"
 	"google.golang.org/appengine"
 	"google.golang.org/appengine/aetest"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -80,6 +84,119 @@ func TestHandler(t *testing.T) {
 	}
 }
 
+type memoryNonceStore map[string]time.Time
+
+func (s memoryNonceStore) CheckAndStore(c context.Context, nonce string, expiry time.Time) (bool, error) {
+	if _, ok := s[nonce]; ok {
+		return false, nil
+	}
+	s[nonce] = expiry
+	return true, nil
+}
+
+func TestHandlerNonce(t *testing.T) {
+	inst, err := aetest.NewInstance(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inst.Close()
+
+	handler := &Handler{
+		Func: func(w http.ResponseWriter, r *http.Request, sr *SignedRequest) {
+			w.WriteHeader(http.StatusOK)
+		},
+		NonceStore: make(memoryNonceStore),
+	}
+
+	req, err := inst.NewRequest("PUT", "/", nil)
+	if err != nil {
+		t.Fatalf("NewRequest failed %v", err)
+	}
+	c := appengine.NewContext(req)
+
+	// a signed request without a nonce is rejected
+	sr := &SignedRequest{
+		Method:     "PUT",
+		URL:        "/",
+		Expiration: time.Now().Add(1 * time.Minute),
+	}
+	if err := sr.Sign(c); err != nil {
+		t.Fatalf("Error signing %v", err)
+	}
+	req, err = testRequestFromSignedRequest(inst, sr)
+	if err != nil {
+		t.Fatalf("failed to get request %v", err)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected bad request without nonce, got %v", rr.Code)
+	}
+
+	// the first use of a nonce works, the replay is rejected
+	sr.Nonce, err = NewNonce()
+	if err != nil {
+		t.Fatalf("NewNonce failed %v", err)
+	}
+	if err := sr.Sign(c); err != nil {
+		t.Fatalf("Error signing %v", err)
+	}
+	for i, expected := range []int{http.StatusOK, http.StatusBadRequest} {
+		req, err = testRequestFromSignedRequest(inst, sr)
+		if err != nil {
+			t.Fatalf("failed to get request %v", err)
+		}
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != expected {
+			t.Errorf("attempt %v: expected %v, got %v", i, expected, rr.Code)
+		}
+	}
+}
+
+func TestHandlerErrors(t *testing.T) {
+	key := newRSAKey(t, "test-key")
+	handler := &Handler{
+		Func: func(w http.ResponseWriter, r *http.Request, sr *SignedRequest) {
+			w.WriteHeader(http.StatusOK)
+		},
+		Verifier: &Verifier{Key: key},
+	}
+	s := &Signer{Key: key}
+
+	tests := []struct {
+		name       string
+		expiration time.Duration
+		tamper     func(r *http.Request)
+		code       int
+	}{
+		{"valid", time.Minute, func(r *http.Request) {}, http.StatusOK},
+		{"expired", -time.Minute, func(r *http.Request) {}, http.StatusBadRequest},
+		{"malformed", time.Minute, func(r *http.Request) { r.Header.Set("Signature", "not base64!") }, http.StatusBadRequest},
+		{"forged", time.Minute, func(r *http.Request) { r.Header.Set("Signature", "Zm9yZ2Vk") }, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		sr := &SignedRequest{
+			Method:     "PUT",
+			URL:        "/",
+			Expiration: time.Now().Add(test.expiration),
+		}
+		if err := s.Sign(context.Background(), sr); err != nil {
+			t.Fatalf("%v: error signing %v", test.name, err)
+		}
+		req, err := sr.HTTPRequest(nil)
+		if err != nil {
+			t.Fatalf("%v: failed to get request %v", test.name, err)
+		}
+		test.tamper(req)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != test.code {
+			t.Errorf("%v: expected %v, got %v", test.name, test.code, rr.Code)
+		}
+	}
+}
+
 func testRequestFromSignedRequest(inst aetest.Instance, sr *SignedRequest) (*http.Request, error) {
 	srReq, err := sr.HTTPRequest(nil)
 	if err != nil {
@@ -104,3 +221,271 @@ func ExampleServer() {
 	http.Handle("/signed", handler)
 	http.ListenAndServe(":8080", nil)
 }
+
+// countingKey counts signature verifications.
+type countingKey struct {
+	*rsaKey
+	verifications int
+}
+
+func (k *countingKey) VerifyBytes(c context.Context, bytes []byte, sig []byte, keyName string) (string, error) {
+	k.verifications++
+	return k.rsaKey.VerifyBytes(c, bytes, sig, keyName)
+}
+
+func TestHandlerAllowlist(t *testing.T) {
+	key := &countingKey{rsaKey: newRSAKey(t, "test-key")}
+	handler := &Handler{
+		Func: func(w http.ResponseWriter, r *http.Request, sr *SignedRequest) {
+			w.WriteHeader(http.StatusOK)
+		},
+		Verifier:       &Verifier{Key: key},
+		AllowedMethods: []string{"GET", "PUT"},
+		AllowedHosts:   []string{"files.example.com"},
+	}
+	s := &Signer{Key: key}
+
+	tests := []struct {
+		method        string
+		url           string
+		code          int
+		verifications int
+	}{
+		{"PUT", "https://files.example.com/upload", http.StatusOK, 1},
+		{"GET", "https://FILES.example.com:8080/upload", http.StatusOK, 1},
+		{"POST", "https://files.example.com/upload", http.StatusBadRequest, 0},
+		{"PUT", "https://evil.example.com/upload", http.StatusBadRequest, 0},
+	}
+	for _, test := range tests {
+		key.verifications = 0
+		sr := &SignedRequest{
+			Method:     test.method,
+			URL:        test.url,
+			Expiration: time.Now().Add(time.Minute),
+		}
+		if err := s.Sign(context.Background(), sr); err != nil {
+			t.Fatalf("%v %v: error signing %v", test.method, test.url, err)
+		}
+		req, err := sr.HTTPRequest(nil)
+		if err != nil {
+			t.Fatalf("%v %v: failed to get request %v", test.method, test.url, err)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != test.code {
+			t.Errorf("%v %v: expected %v, got %v", test.method, test.url, test.code, rr.Code)
+		}
+		if key.verifications != test.verifications {
+			t.Errorf("%v %v: expected %v verifications, got %v", test.method, test.url, test.verifications, key.verifications)
+		}
+	}
+}
+
+// recordingLogger records log messages by level.
+type recordingLogger struct {
+	messages map[string][]string
+}
+
+func (l *recordingLogger) logf(level, format string, args ...interface{}) {
+	if l.messages == nil {
+		l.messages = make(map[string][]string)
+	}
+	l.messages[level] = append(l.messages[level], fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Debugf(c context.Context, format string, args ...interface{}) {
+	l.logf("debug", format, args...)
+}
+
+func (l *recordingLogger) Warningf(c context.Context, format string, args ...interface{}) {
+	l.logf("warning", format, args...)
+}
+
+func (l *recordingLogger) Errorf(c context.Context, format string, args ...interface{}) {
+	l.logf("error", format, args...)
+}
+
+func TestHandlerLogging(t *testing.T) {
+	key := newRSAKey(t, "test-key")
+	s := &Signer{Key: key}
+
+	tests := []struct {
+		name       string
+		expiration time.Duration
+		tamper     func(r *http.Request)
+		level      string
+		reason     string
+	}{
+		{"valid", time.Minute, func(r *http.Request) {}, "debug", "verified PUT /"},
+		{"expired", -time.Minute, func(r *http.Request) {}, "warning", "ErrExpired"},
+		{"malformed", time.Minute, func(r *http.Request) { r.Header.Set("Signature", "not base64!") }, "warning", "ErrMalformedSignature"},
+		{"forged", time.Minute, func(r *http.Request) { r.Header.Set("Signature", "Zm9yZ2Vk") }, "warning", "ErrBadSignature"},
+	}
+	for _, test := range tests {
+		logger := &recordingLogger{}
+		handler := &Handler{
+			Func: func(w http.ResponseWriter, r *http.Request, sr *SignedRequest) {
+				w.WriteHeader(http.StatusOK)
+			},
+			Verifier: &Verifier{Key: key},
+			Logger:   logger,
+		}
+		sr := &SignedRequest{
+			Method:     "PUT",
+			URL:        "/",
+			Expiration: time.Now().Add(test.expiration),
+		}
+		if err := s.Sign(context.Background(), sr); err != nil {
+			t.Fatalf("%v: error signing %v", test.name, err)
+		}
+		req, err := sr.HTTPRequest(nil)
+		if err != nil {
+			t.Fatalf("%v: failed to get request %v", test.name, err)
+		}
+		test.tamper(req)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if len(logger.messages) != 1 || len(logger.messages[test.level]) != 1 {
+			t.Fatalf("%v: expected one %v message, got %v", test.name, test.level, logger.messages)
+		}
+		if msg := logger.messages[test.level][0]; !strings.Contains(msg, test.reason) {
+			t.Errorf("%v: expected message %q to contain %q", test.name, msg, test.reason)
+		}
+	}
+
+	// Without a Logger, nothing is logged and requests are still handled.
+	handler := &Handler{
+		Func: func(w http.ResponseWriter, r *http.Request, sr *SignedRequest) {
+			w.WriteHeader(http.StatusOK)
+		},
+		Verifier: &Verifier{Key: key},
+	}
+	req, err := http.NewRequest("PUT", "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected %v, got %v", http.StatusBadRequest, rr.Code)
+	}
+}
+
+func TestHandlerSeenCache(t *testing.T) {
+	key := newRSAKey(t, "test-key")
+	handler := &Handler{
+		Func: func(w http.ResponseWriter, r *http.Request, sr *SignedRequest) {
+			w.WriteHeader(http.StatusOK)
+		},
+		Verifier:  &Verifier{Key: key},
+		SeenCache: NewMemorySeenCache(),
+	}
+	sr := &SignedRequest{
+		Method:     "POST",
+		URL:        "/transfer",
+		Expiration: time.Now().Add(time.Minute),
+	}
+	if err := (&Signer{Key: key}).Sign(context.Background(), sr); err != nil {
+		t.Fatalf("Error signing %v", err)
+	}
+
+	codes := []int{http.StatusOK, http.StatusBadRequest}
+	for i, code := range codes {
+		req, err := sr.HTTPRequest(nil)
+		if err != nil {
+			t.Fatalf("Failed to get request %v", err)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != code {
+			t.Errorf("Attempt %v: expected %v, got %v", i+1, code, rr.Code)
+		}
+	}
+
+	// Replaying with another encoding of the signature is rejected too.
+	req, err := sr.HTTPRequest(nil)
+	if err != nil {
+		t.Fatalf("Failed to get request %v", err)
+	}
+	req.Header.Set("Signature", reencode(sr.Signature))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a re-encoded replay to be rejected with %v, got %v", http.StatusBadRequest, rr.Code)
+	}
+
+	// Requests with only co-signatures have no Signature, but are still
+	// told apart.
+	for _, url := range []string{"/transfer/1", "/transfer/2"} {
+		sr := &SignedRequest{
+			Method:     "POST",
+			URL:        url,
+			Expiration: time.Now().Add(time.Minute),
+		}
+		if err := (&Signer{Key: key}).CoSign(context.Background(), sr); err != nil {
+			t.Fatalf("Error co-signing %v", err)
+		}
+		req, err := sr.HTTPRequest(nil)
+		if err != nil {
+			t.Fatalf("Failed to get request %v", err)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%v: expected %v, got %v", url, http.StatusOK, rr.Code)
+		}
+	}
+}
+
+func TestMemorySeenCache(t *testing.T) {
+	now := time.Now()
+	cache := NewMemorySeenCache()
+	cache.now = func() time.Time { return now }
+
+	if !cache.CheckAndAdd("a", now.Add(time.Minute)) || !cache.CheckAndAdd("b", now.Add(time.Hour)) {
+		t.Fatal("Expected new keys to be added")
+	}
+	if cache.CheckAndAdd("a", now.Add(time.Minute)) {
+		t.Fatal("Expected a seen key to be rejected")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if !cache.CheckAndAdd("a", now.Add(time.Minute)) {
+		t.Error("Expected an expired key to be added again")
+	}
+
+	// Expired keys are evicted once the cache reaches minEvictSize.
+	for i := cache.Len(); i < minEvictSize-1; i++ {
+		cache.CheckAndAdd(fmt.Sprint("expiring-", i), now.Add(time.Minute))
+	}
+	now = now.Add(2 * time.Minute)
+	if cache.Len() != minEvictSize-1 {
+		t.Fatalf("Expected %v keys before eviction, got %v", minEvictSize-1, cache.Len())
+	}
+	cache.CheckAndAdd("c", now.Add(time.Minute))
+	if cache.Len() != 2 {
+		t.Errorf("Expected the expired keys to be evicted, got %v entries", cache.Len())
+	}
+}
+
+func TestMemorySeenCacheConcurrent(t *testing.T) {
+	cache := NewMemorySeenCache()
+	exp := time.Now().Add(time.Minute)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	added := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if cache.CheckAndAdd("replayed", exp) {
+				mu.Lock()
+				added++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if added != 1 {
+		t.Errorf("Expected exactly one concurrent add to succeed, got %v", added)
+	}
+}
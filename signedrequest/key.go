@@ -0,0 +1,35 @@
+package signedrequest
+
+import (
+	"github.com/drichardson/appengine/signature"
+	"golang.org/x/net/context"
+)
+
+// KeySigner signs bytes with a private key. Implementations should produce
+// RSA PKCS #1 v1.5 signatures of the SHA-256 digest, matching AlgorithmRSASHA256.
+type KeySigner interface {
+	SignBytes(c context.Context, bytes []byte) (keyName string, sig []byte, err error)
+}
+
+// KeyVerifier verifies signatures produced by a KeySigner. keyName is empty
+// if the signer's key name is unknown, in which case any key may verify sig.
+// VerifyBytes returns the name of the key that verified sig, which must be
+// keyName if it isn't empty.
+type KeyVerifier interface {
+	VerifyBytes(c context.Context, bytes []byte, sig []byte, keyName string) (verifiedKeyName string, err error)
+}
+
+// appEngineKey signs with signature.SignBytes and verifies against
+// appengine.PublicCertificates. It is the default KeySigner and KeyVerifier.
+type appEngineKey struct{}
+
+func (appEngineKey) SignBytes(c context.Context, bytes []byte) (string, []byte, error) {
+	return signature.SignBytes(c, bytes)
+}
+
+func (appEngineKey) VerifyBytes(c context.Context, bytes []byte, sig []byte, keyName string) (string, error) {
+	if keyName != "" {
+		return keyName, signature.VerifyBytesWithKeyName(c, bytes, sig, keyName)
+	}
+	return signature.VerifyBytesReturningKey(c, bytes, sig)
+}
@@ -0,0 +1,170 @@
+package signedrequest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"golang.org/x/net/context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// rsaKey is an in-memory KeySigner and KeyVerifier, so requests can be
+// signed and verified without App Engine.
+type rsaKey struct {
+	name string
+	key  *rsa.PrivateKey
+}
+
+func newRSAKey(t *testing.T, name string) *rsaKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key. %v", err)
+	}
+	return &rsaKey{name: name, key: key}
+}
+
+func (k *rsaKey) SignBytes(c context.Context, bytes []byte) (string, []byte, error) {
+	hashed := sha256.Sum256(bytes)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, k.key, crypto.SHA256, hashed[:])
+	return k.name, sig, err
+}
+
+func (k *rsaKey) VerifyBytes(c context.Context, bytes []byte, sig []byte, keyName string) (string, error) {
+	if keyName != "" && keyName != k.name {
+		return "", ErrBadSignature
+	}
+	hashed := sha256.Sum256(bytes)
+	if err := rsa.VerifyPKCS1v15(&k.key.PublicKey, crypto.SHA256, hashed[:], sig); err != nil {
+		return "", err
+	}
+	return k.name, nil
+}
+
+func TestKeySignerAndVerifier(t *testing.T) {
+	key := newRSAKey(t, "test-key")
+	s := &Signer{Key: key}
+	v := &Verifier{Key: key}
+	c := context.Background()
+
+	r := &SignedRequest{
+		Method:     "GET",
+		URL:        "https://howdy",
+		Expiration: time.Now().Add(1 * time.Hour),
+	}
+	if err := s.Sign(c, r); err != nil {
+		t.Fatalf("Failed to sign. %v", err)
+	}
+	if r.KeyName != "test-key" {
+		t.Fatalf("Expected key name test-key, got %v", r.KeyName)
+	}
+	if err := v.Verify(c, r); err != nil {
+		t.Fatalf("Expected signed request to verify. %v", err)
+	}
+
+	r.URL = "https://howdy/other"
+	if err := v.Verify(c, r); err == nil {
+		t.Fatal("Expected tampered request to fail verification")
+	}
+
+	other := &Verifier{Key: newRSAKey(t, "test-key")}
+	r.URL = "https://howdy"
+	if err := other.Verify(c, r); err != ErrBadSignature {
+		t.Fatalf("Expected ErrBadSignature with a different key but got %v", err)
+	}
+
+	r.Signature = "not base64!"
+	if err := v.Verify(c, r); err != ErrMalformedSignature {
+		t.Fatalf("Expected ErrMalformedSignature but got %v", err)
+	}
+}
+
+func TestRequiredHeaders(t *testing.T) {
+	key := newRSAKey(t, "test-key")
+	s := &Signer{Key: key, RequiredHeaders: []string{"content-type"}}
+	v := &Verifier{Key: key, RequiredHeaders: []string{"Content-Type"}}
+	c := context.Background()
+
+	r := &SignedRequest{
+		Method:     "PUT",
+		URL:        "https://howdy",
+		Expiration: time.Now().Add(1 * time.Hour),
+	}
+	if err := s.Sign(c, r); err != ErrMissingRequiredHeader {
+		t.Fatalf("Expected ErrMissingRequiredHeader but got %v", err)
+	}
+
+	r.Headers = http.Header{"Content-Type": {"image/png"}}
+	if err := s.Sign(c, r); err != nil {
+		t.Fatalf("Failed to sign. %v", err)
+	}
+	req, err := r.HTTPRequest(nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request. %v", err)
+	}
+	r2, err := ParseHTTPRequest(req)
+	if err != nil {
+		t.Fatalf("Failed to parse HTTP request. %v", err)
+	}
+	if err := v.Verify(c, r2); err != nil {
+		t.Fatalf("Expected signed request to verify. %v", err)
+	}
+
+	// Removing the header from the Signed-Headers list must fail, even
+	// though the remaining fields would otherwise be consistent.
+	req.Header.Del("Signed-Headers")
+	r2, err = ParseHTTPRequest(req)
+	if err != nil {
+		t.Fatalf("Failed to parse HTTP request. %v", err)
+	}
+	if err := v.Verify(c, r2); err != ErrMissingRequiredHeader {
+		t.Fatalf("Expected ErrMissingRequiredHeader but got %v", err)
+	}
+}
+
+func TestDefaultPortsVerify(t *testing.T) {
+	key := newRSAKey(t, "test-key")
+	s := &Signer{Key: key}
+	v := &Verifier{Key: key}
+	c := context.Background()
+
+	tests := []struct {
+		signed   string
+		verified string
+	}{
+		{"https://howdy:443/path", "https://howdy/path"},
+		{"https://howdy/path", "https://HOWDY:443/path"},
+		{"http://howdy:80/path", "HTTP://howdy/path"},
+		{"http://howdy/a/b", "http://howdy/a//b"},
+	}
+	for _, test := range tests {
+		r := &SignedRequest{
+			Method:     "GET",
+			URL:        test.signed,
+			Expiration: time.Now().Add(1 * time.Hour),
+		}
+		if err := s.Sign(c, r); err != nil {
+			t.Fatalf("Failed to sign. %v", err)
+		}
+		r.URL = test.verified
+		if err := v.Verify(c, r); err != nil {
+			t.Errorf("Expected %v signed as %v to verify. %v", test.verified, test.signed, err)
+		}
+	}
+
+	// The path is still case-sensitive.
+	r := &SignedRequest{
+		Method:     "GET",
+		URL:        "https://howdy/Path",
+		Expiration: time.Now().Add(1 * time.Hour),
+	}
+	if err := s.Sign(c, r); err != nil {
+		t.Fatalf("Failed to sign. %v", err)
+	}
+	r.URL = "https://howdy/path"
+	if err := v.Verify(c, r); err != ErrBadSignature {
+		t.Errorf("Expected ErrBadSignature for a different path case but got %v", err)
+	}
+}
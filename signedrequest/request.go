@@ -3,13 +3,19 @@
 package signedrequest
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
-	"github.com/drichardson/appengine/signature"
 	"golang.org/x/net/context"
-	"google.golang.org/appengine"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
@@ -18,45 +24,123 @@ import (
 
 // SignedRequest contains request parameters, an expiration, and signature.
 // Method, URL, and Expiration should be set by the user.
-// Headers are optional. Signature is set by the Sign function. All
-// the fields (except Signature) are signed by the Sign function.
+// Headers, BodyHash, Nonce, Claims, and BoundTo are optional. Signature is set by the Sign function. All
+// the fields (except Signature, KeyName, and Signatures) are signed by the Sign function.
 type SignedRequest struct {
 	Method     string      `json:"method"`
 	URL        string      `json:"url"`
 	Expiration time.Time   `json:"expiration"`
 	Headers    http.Header `json:"headers"`
-	Signature  string      `json:"signature"`
+	// BodyHash is the hex encoded SHA-256 digest of the request body. If empty,
+	// the body is not part of the signature. See HashBody.
+	BodyHash string `json:"bodyHash,omitempty"`
+	// Nonce is an optional single use value. Handlers configured with a
+	// NonceStore reject requests whose nonce has already been seen. See NewNonce.
+	Nonce string `json:"nonce,omitempty"`
+	// Claims is optional application data, such as a user ID or scope, that
+	// the server can trust once the request verifies.
+	Claims map[string]string `json:"claims,omitempty"`
+	// BoundTo optionally restricts the request to one client. An IP address,
+	// such as 203.0.113.7, or a CIDR, such as 203.0.113.0/24, must contain
+	// the client's IP. Anything else is a subject, such as a user ID, that
+	// must match Handler.Subject. See VerifyBoundTo.
+	BoundTo   string `json:"boundTo,omitempty"`
+	Signature string `json:"signature"`
+	// KeyName is the name of the key that produced Signature. It is set by
+	// Sign and is not itself signed. If empty, Verify tries every public
+	// certificate.
+	KeyName string `json:"keyName,omitempty"`
+	// Algorithm identifies the signature scheme. Sign sets it to
+	// AlgorithmRSASHA256 if empty.
+	Algorithm string `json:"algorithm,omitempty"`
+	// Signatures holds co-signatures of the same fields by other keys,
+	// added by Signer.CoSign.
+	Signatures []KeySignature `json:"signatures,omitempty"`
+}
+
+// KeySignature is a base64 encoded signature and the name of the key that
+// produced it.
+type KeySignature struct {
+	KeyName   string `json:"keyName,omitempty"`
+	Signature string `json:"signature"`
+}
+
+// signatures returns Signature, if set, followed by Signatures.
+func (p *SignedRequest) signatures() []KeySignature {
+	sigs := make([]KeySignature, 0, 1+len(p.Signatures))
+	if p.Signature != "" {
+		sigs = append(sigs, KeySignature{KeyName: p.KeyName, Signature: p.Signature})
+	}
+	return append(sigs, p.Signatures...)
+}
+
+// AlgorithmRSASHA256 is the RSA PKCS #1 v1.5 with SHA-256 scheme used by
+// appengine.SignBytes.
+const AlgorithmRSASHA256 = "RSA-SHA256"
+
+// Error codes returned when required SignedRequest fields are missing.
+var (
+	ErrMissingMethod     = errors.New("ErrMissingMethod")
+	ErrMissingURL        = errors.New("ErrMissingURL")
+	ErrMissingExpiration = errors.New("ErrMissingExpiration")
+)
+
+// NewSignedRequest returns an unsigned SignedRequest for the given method,
+// URL, and expiration, or an error if any of them are empty.
+func NewSignedRequest(method, url string, expiration time.Time) (*SignedRequest, error) {
+	p := &SignedRequest{
+		Method:     method,
+		URL:        url,
+		Expiration: expiration,
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// validate checks that the required fields are set.
+func (p *SignedRequest) validate() error {
+	if p.Method == "" {
+		return ErrMissingMethod
+	}
+	if p.URL == "" {
+		return ErrMissingURL
+	}
+	if p.Expiration.IsZero() {
+		return ErrMissingExpiration
+	}
+	return nil
 }
 
 // Sign signs the request parameters and sets the Signature field.
 // c must be an App Engine context created with appengine.NewContext.
+// Method, URL, and Expiration must be set. Sign is equivalent to using
+// a zero Signer, which doesn't limit the expiration.
 func (p *SignedRequest) Sign(c context.Context) error {
-	_, sig, err := appengine.SignBytes(c, []byte(p.signingString()))
-	if err != nil {
-		return err
-	}
-	p.Signature = base64.StdEncoding.EncodeToString(sig)
-	return nil
+	var s Signer
+	return s.Sign(c, p)
 }
 
 // Error code that indicates the request signature has expired.
 var ErrExpired = errors.New("ErrExpired")
 
+// Error code that indicates the request body does not match BodyHash.
+var ErrBodyHashMismatch = errors.New("ErrBodyHashMismatch")
+
+// Error code that indicates the signature Algorithm is not supported.
+var ErrUnknownAlgorithm = errors.New("ErrUnknownAlgorithm")
+
+// Error code that indicates the method or URL a request was signed for
+// doesn't match the request it arrived with.
+var ErrRequestMismatch = errors.New("ErrRequestMismatch")
+
 // Verify verifies the request signature. c must be an appengine context
-// created with appengine.NewContext.
+// created with appengine.NewContext. Verify is equivalent to using
+// a zero Verifier, which allows no leeway on the expiration.
 func (p *SignedRequest) Verify(c context.Context) error {
-	sig, err := base64.StdEncoding.DecodeString(p.Signature)
-	if err != nil {
-		return err
-	}
-	err = signature.VerifyBytes(c, []byte(p.signingString()), sig)
-	if err != nil {
-		return err
-	}
-	if time.Now().After(p.Expiration) {
-		return ErrExpired
-	}
-	return nil
+	var v Verifier
+	return v.Verify(c, p)
 }
 
 // signingString creates a canonical string out of the SignedRequest
@@ -65,6 +149,10 @@ func (p *SignedRequest) Verify(c context.Context) error {
 // seconds and also headers which are stored in a map, which Go explicially
 // gaurentees will not be iterated through in the same order.
 // See http://golang.org/ref/spec#RangeClause for information on range and map.
+//
+// Optional components are only included when set, so requests signed before
+// they existed still verify. They are written as lowercase "name:value" lines,
+// which can't be confused with the canonical "Name: value" header lines.
 func (p *SignedRequest) signingString() string {
 	// Sort headers by CanonicalHeaderKey to have a consistent sort, even if transformed
 	// by intermediate http proxies.
@@ -74,22 +162,95 @@ func (p *SignedRequest) signingString() string {
 	}
 	sort.Strings(sortedHeaders)
 
-	// The method and url are case-sensitive, so don't transform them.
+	// The method and url are case-sensitive, so don't transform them, other than
+	// normalizing the url with canonicalURL.
 	// http://www.w3.org/Protocols/rfc2616/rfc2616-sec5.html
 	// Use a UNIX time, since there are multiple equivalent representations
 	// of RFC 3339 time, but we want to treat them all as the same for signing purposes.
 	components := []string{
 		p.Method,
-		p.URL,
+		canonicalURL(p.URL),
 		strconv.FormatInt(p.Expiration.Unix(), 10),
 	}
+	if p.Algorithm != "" {
+		components = append(components, "algorithm:"+p.Algorithm)
+	}
+	if p.BodyHash != "" {
+		components = append(components, "body-hash:"+p.BodyHash)
+	}
+	if p.Nonce != "" {
+		components = append(components, "nonce:"+p.Nonce)
+	}
+	if claims := encodeClaims(p.Claims); claims != "" {
+		components = append(components, "claims:"+claims)
+	}
+	if p.BoundTo != "" {
+		components = append(components, "bound-to:"+p.BoundTo)
+	}
 	components = append(components, sortedHeaders...)
 
 	return strings.Join(components, "\n")
 }
 
+// canonicalURL returns rawurl normalized so that equivalent URLs sign the same,
+// even if a proxy rewrites one into another. The scheme and host are lowercased,
+// default ports are dropped, redundant path segments are collapsed, and query
+// parameters are sorted by key and then by value, unless the query doesn't
+// parse. The path is otherwise left
+// alone, since it is case-sensitive. If rawurl can't be parsed, it is returned
+// unchanged.
+func canonicalURL(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = canonicalHost(u.Scheme, u.Host)
+	if escaped := u.EscapedPath(); escaped != cleanPath(escaped) {
+		cleaned := cleanPath(escaped)
+		if unescaped, err := url.PathUnescape(cleaned); err == nil {
+			u.Path = unescaped
+			u.RawPath = cleaned
+		}
+	}
+	// A query that doesn't parse is left as is, rather than dropping the
+	// pairs that don't, which the application could still read.
+	if q, err := url.ParseQuery(u.RawQuery); u.RawQuery != "" && err == nil {
+		for _, vals := range q {
+			sort.Strings(vals)
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// canonicalHost returns host lowercased and without the default port of
+// scheme, which must be lowercase.
+func canonicalHost(scheme, host string) string {
+	host = strings.ToLower(host)
+	u := &url.URL{Host: host}
+	if port := u.Port(); (scheme == "https" && port == "443") || (scheme == "http" && port == "80") {
+		host = strings.TrimSuffix(host, ":"+port)
+	}
+	return host
+}
+
+// cleanPath collapses repeated slashes and "." and ".." segments in p,
+// keeping a trailing slash.
+func cleanPath(p string) string {
+	if p == "" {
+		return p
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
 // HTTPRequest creates an http.Request from the SignedRequest.
-// The body is not part of the signature.
+// The body is only part of the signature if BodyHash is set, in which
+// case body must produce the content BodyHash was computed from.
 func (p *SignedRequest) HTTPRequest(body io.Reader) (*http.Request, error) {
 	r, err := http.NewRequest(p.Method, p.URL, body)
 	if err != nil {
@@ -104,12 +265,39 @@ func (p *SignedRequest) HTTPRequest(body io.Reader) (*http.Request, error) {
 	}
 	r.Header.Set("Signature", p.Signature)
 	r.Header.Set("Signature-Expiration", p.Expiration.Format(time.RFC3339))
+	r.Header.Set("Signature-Method", p.Method)
+	r.Header.Set("Signature-URL", p.URL)
+	if p.KeyName != "" {
+		r.Header.Set("Signature-Key-Name", p.KeyName)
+	}
+	if p.Algorithm != "" {
+		r.Header.Set("Signature-Algorithm", p.Algorithm)
+	}
+	if p.BodyHash != "" {
+		r.Header.Set("Signature-Body-Hash", p.BodyHash)
+	}
+	if p.Nonce != "" {
+		r.Header.Set("Signature-Nonce", p.Nonce)
+	}
+	if claims := encodeClaims(p.Claims); claims != "" {
+		r.Header.Set("Signature-Claims", claims)
+	}
+	if p.BoundTo != "" {
+		r.Header.Set("Signature-Bound-To", p.BoundTo)
+	}
+	for _, sig := range p.Signatures {
+		r.Header.Add("Signature-Cosignature", url.QueryEscape(sig.KeyName)+":"+sig.Signature)
+	}
 	r.Header[http.CanonicalHeaderKey("Signed-Headers")] = signedHeaders
 	return r, nil
 }
 
 // ParseHTTPRequest parses the SignedRequest from an http.Request
-// created with HTTPRequest.
+// created with HTTPRequest. The method and URL that were signed are taken
+// from the request headers and must match the method, path, and query of r,
+// and the host of r if the signed URL is absolute, otherwise
+// ErrRequestMismatch is returned. Requests without those headers
+// use the method and URL of r.
 func ParseHTTPRequest(r *http.Request) (*SignedRequest, error) {
 
 	signature := r.Header.Get("Signature")
@@ -122,17 +310,219 @@ func ParseHTTPRequest(r *http.Request) (*SignedRequest, error) {
 	}
 
 	signedHeaders := make(http.Header)
-	for _, key := range signedHeaderKeys {
-		signedHeaders[key] = r.Header[http.CanonicalHeaderKey(key)]
+	for _, key := range canonicalHeaderKeys(signedHeaderKeys) {
+		signedHeaders[key] = r.Header[key]
+	}
+
+	method := r.Method
+	if signedMethod := r.Header.Get("Signature-Method"); signedMethod != "" {
+		if signedMethod != method {
+			return nil, ErrRequestMismatch
+		}
+	}
+	rawurl := r.URL.String()
+	if signedURL := r.Header.Get("Signature-URL"); signedURL != "" {
+		if !sameResource(signedURL, r) {
+			return nil, ErrRequestMismatch
+		}
+		rawurl = signedURL
+	}
+
+	claims, err := decodeClaims(r.Header.Get("Signature-Claims"))
+	if err != nil {
+		return nil, err
+	}
+	cosignatures, err := parseCosignatures(r.Header[http.CanonicalHeaderKey("Signature-Cosignature")])
+	if err != nil {
+		return nil, err
 	}
 
 	p := &SignedRequest{
-		Method:     r.Method,
-		URL:        r.URL.String(),
+		Method:     method,
+		URL:        rawurl,
 		Expiration: expiration,
 		Headers:    signedHeaders,
+		BodyHash:   r.Header.Get("Signature-Body-Hash"),
+		Nonce:      r.Header.Get("Signature-Nonce"),
+		Claims:     claims,
+		BoundTo:    r.Header.Get("Signature-Bound-To"),
 		Signature:  signature,
+		KeyName:    r.Header.Get("Signature-Key-Name"),
+		Algorithm:  r.Header.Get("Signature-Algorithm"),
+		Signatures: cosignatures,
+	}
+
+	return p, nil
+}
+
+// canonicalHeaderKeys returns the header names listed in Signed-Headers
+// values in canonical form, sorted and without duplicates, as signingString
+// uses them. Intermediaries may change their case, repeat them, or combine
+// repeated Signed-Headers into one comma separated value.
+func canonicalHeaderKeys(values []string) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, v := range values {
+		for _, key := range strings.Split(v, ",") {
+			key = http.CanonicalHeaderKey(strings.TrimSpace(key))
+			if key == "" || seen[key] {
+				continue
+			}
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// encodeClaims returns claims as a query string sorted by key, which is
+// unambiguous for any keys and values.
+func encodeClaims(claims map[string]string) string {
+	values := make(url.Values, len(claims))
+	for k, v := range claims {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+// decodeClaims parses claims encoded by encodeClaims.
+func decodeClaims(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	values, err := url.ParseQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	claims := make(map[string]string, len(values))
+	for k, v := range values {
+		claims[k] = v[0]
+	}
+	return claims, nil
+}
+
+// parseCosignatures parses Signature-Cosignature header values, each a query
+// escaped key name and a base64 signature separated by a colon. Proxies may
+// combine repeated headers into one comma separated value, which is safe to
+// split because neither part can contain a comma.
+func parseCosignatures(values []string) ([]KeySignature, error) {
+	var sigs []KeySignature
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			item = strings.TrimSpace(item)
+			i := strings.Index(item, ":")
+			if i < 0 {
+				return nil, ErrMalformedSignature
+			}
+			keyName, err := url.QueryUnescape(item[:i])
+			if err != nil {
+				return nil, ErrMalformedSignature
+			}
+			sigs = append(sigs, KeySignature{KeyName: keyName, Signature: item[i+1:]})
+		}
 	}
+	return sigs, nil
+}
+
+// sameResource reports whether signedURL has the same path and query as r,
+// and, if signedURL is absolute, the same host. The scheme isn't compared,
+// since a server usually only sees the path and query of the URL it was
+// sent to, and the Host header.
+func sameResource(signedURL string, r *http.Request) bool {
+	su, err := url.Parse(signedURL)
+	if err != nil {
+		return false
+	}
+	if su.Host != "" {
+		scheme := strings.ToLower(su.Scheme)
+		if canonicalHost(scheme, su.Host) != canonicalHost(scheme, requestHost(r)) {
+			return false
+		}
+	}
+	u := r.URL
+	return resourcePath(su) == resourcePath(u) &&
+		canonicalURL("?"+su.RawQuery) == canonicalURL("?"+u.RawQuery)
+}
+
+// requestHost returns the host r was sent to: the Host header on a server,
+// or the host of the URL on a client.
+func requestHost(r *http.Request) string {
+	if r.Host != "" {
+		return r.Host
+	}
+	return r.URL.Host
+}
+
+// resourcePath returns the cleaned escaped path of u. An empty path is "/",
+// which is what a server sees for a URL like https://example.com.
+func resourcePath(u *url.URL) string {
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	}
+	return cleanPath(p)
+}
+
+// MarshalSignedRequest returns the JSON encoding of p in a canonical form,
+// so that equal requests always produce the same bytes. Header keys are
+// sorted and Expiration is written in UTC with whole seconds, which is all
+// the precision the signature covers.
+func MarshalSignedRequest(p *SignedRequest) ([]byte, error) {
+	canonical := *p
+	canonical.Expiration = time.Unix(p.Expiration.Unix(), 0).UTC()
+	return json.Marshal(&canonical)
+}
 
+// UnmarshalSignedRequest parses a SignedRequest encoded by MarshalSignedRequest.
+// The result must still be verified.
+func UnmarshalSignedRequest(data []byte) (*SignedRequest, error) {
+	p := &SignedRequest{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
 	return p, nil
 }
+
+// NewNonce returns a random value suitable for Nonce.
+func NewNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashBody reads body and returns its hex encoded SHA-256 digest, suitable
+// for BodyHash, along with a reader that replays the content that was read.
+// The entire body is buffered in memory.
+func HashBody(body io.Reader) (string, io.Reader, error) {
+	var buf bytes.Buffer
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(&buf, h), body); err != nil {
+		return "", nil, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), &buf, nil
+}
+
+// VerifyBody checks that the body of r matches BodyHash. r.Body is replaced
+// with a buffered copy so it can still be read by the caller. If BodyHash
+// is empty, the body is not checked.
+func (p *SignedRequest) VerifyBody(r *http.Request) error {
+	if p.BodyHash == "" {
+		return nil
+	}
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	hash, body, err := HashBody(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return err
+	}
+	r.Body = ioutil.NopCloser(body)
+	if hash != p.BodyHash {
+		return ErrBodyHashMismatch
+	}
+	return nil
+}
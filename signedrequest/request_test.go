@@ -1,7 +1,13 @@
 package signedrequest
 
 import (
+	"golang.org/x/net/context"
 	"google.golang.org/appengine/aetest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -44,15 +50,504 @@ func TestSignedRequest(t *testing.T) {
 
 	// equality check... though note expiration times may be off by a fraction of a second. The
 	// signature calcualtion, however, is based on the floor of the seconds.
-	if r.Method != r2.Method || r.URL != r2.URL || r.Expiration.Unix() != r2.Expiration.Unix() || r.Signature != r2.Signature {
+	if r.Method != r2.Method || r.URL != r2.URL || r.Expiration.Unix() != r2.Expiration.Unix() || r.Signature != r2.Signature || r.KeyName != r2.KeyName {
 		t.Fatal("r1 != r2")
 	}
 
-	r.Expiration = time.Now().Add(-1 * time.Second)
+	if r.KeyName == "" {
+		t.Fatal("Expected Sign to set KeyName")
+	}
+	r2.KeyName = "not-a-key"
+	if err := r2.Verify(c); err == nil {
+		t.Fatal("Expected verification with an unknown key name to fail")
+	}
+	r2.KeyName = ""
+	if err := r2.Verify(c); err != nil {
+		t.Fatalf("Expected verification without a key name to try all certificates. %v", err)
+	}
+
+	// Move the clock past the expiration instead of signing with a time
+	// in the past.
+	v := &Verifier{Now: func() time.Time { return r.Expiration.Add(1 * time.Second) }}
+	if err := v.Verify(c, r); err != ErrExpired {
+		t.Fatalf("Expected verification to fail with ErrExpired but got %v", err)
+	}
+}
+
+func TestHashBody(t *testing.T) {
+	hash, body, err := HashBody(strings.NewReader("hello, world!"))
+	if err != nil {
+		t.Fatalf("Failed to hash body. %v", err)
+	}
+	expected := "68e656b251e67e8358bef8483ab0d51c6619f3e7a1a9f0e75838d41ff368f728"
+	if hash != expected {
+		t.Fatalf("Expected hash %v but got %v", expected, hash)
+	}
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		t.Fatalf("Failed to read buffered body. %v", err)
+	}
+	if string(b) != "hello, world!" {
+		t.Fatalf("Buffered body does not match. Got %v", string(b))
+	}
+
+	sr := &SignedRequest{
+		Method:     "PUT",
+		URL:        "/upload",
+		Expiration: time.Now().Add(1 * time.Minute),
+		BodyHash:   hash,
+	}
+	req, err := sr.HTTPRequest(strings.NewReader("hello, world!"))
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request. %v", err)
+	}
+	sr2, err := ParseHTTPRequest(req)
+	if err != nil {
+		t.Fatalf("Failed to parse HTTP request. %v", err)
+	}
+	if sr2.BodyHash != hash {
+		t.Fatalf("Expected body hash to be carried in header. Got %v", sr2.BodyHash)
+	}
+	if err := sr2.VerifyBody(req); err != nil {
+		t.Fatalf("Expected body to verify. %v", err)
+	}
+
+	req, err = sr.HTTPRequest(strings.NewReader("goodbye, world!"))
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request. %v", err)
+	}
+	if err := sr.VerifyBody(req); err != ErrBodyHashMismatch {
+		t.Fatalf("Expected ErrBodyHashMismatch but got %v", err)
+	}
+}
+
+func TestVerifierLeeway(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	now := time.Unix(1500000000, 0)
+	r := &SignedRequest{
+		Method:     "GET",
+		URL:        "https://howdy",
+		Expiration: now,
+	}
 	if err := r.Sign(c); err != nil {
 		t.Fatalf("Failed to sign. %v", err)
 	}
-	if err := r.Verify(c); err != ErrExpired {
-		t.Fatalf("Expected verification to fail with ErrExpired but got %v", err)
+
+	v := &Verifier{Now: func() time.Time { return now }}
+	if err := v.Verify(c, r); err != nil {
+		t.Fatalf("Expected verification at the expiration time to succeed. %v", err)
+	}
+	v.Now = func() time.Time { return now.Add(1 * time.Second) }
+	if err := v.Verify(c, r); err != ErrExpired {
+		t.Fatalf("Expected ErrExpired with no leeway but got %v", err)
+	}
+	v.Leeway = 1 * time.Minute
+	if err := v.Verify(c, r); err != nil {
+		t.Fatalf("Expected verification within leeway to succeed. %v", err)
+	}
+	v.Now = func() time.Time { return now.Add(1*time.Minute + 1*time.Second) }
+	if err := v.Verify(c, r); err != ErrExpired {
+		t.Fatalf("Expected ErrExpired past leeway but got %v", err)
+	}
+}
+
+func TestNewSignedRequest(t *testing.T) {
+	exp := time.Now().Add(1 * time.Hour)
+	tests := []struct {
+		method     string
+		url        string
+		expiration time.Time
+		err        error
+	}{
+		{"GET", "https://howdy", exp, nil},
+		{"", "https://howdy", exp, ErrMissingMethod},
+		{"GET", "", exp, ErrMissingURL},
+		{"GET", "https://howdy", time.Time{}, ErrMissingExpiration},
+	}
+	for _, test := range tests {
+		r, err := NewSignedRequest(test.method, test.url, test.expiration)
+		if err != test.err {
+			t.Errorf("NewSignedRequest(%q, %q, %v): expected error %v but got %v", test.method, test.url, test.expiration, test.err, err)
+			continue
+		}
+		if err == nil && (r.Method != test.method || r.URL != test.url || !r.Expiration.Equal(test.expiration)) {
+			t.Errorf("NewSignedRequest(%q, %q, %v): fields not set, got %+v", test.method, test.url, test.expiration, r)
+		}
+	}
+
+	// Signing must fail before any signature is produced.
+	r := &SignedRequest{URL: "https://howdy", Expiration: exp}
+	if err := r.Sign(context.Background()); err != ErrMissingMethod {
+		t.Fatalf("Expected ErrMissingMethod but got %v", err)
+	}
+	if r.Signature != "" {
+		t.Fatal("Expected no signature to be set")
+	}
+}
+
+func TestAlgorithmTampering(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	r := &SignedRequest{
+		Method:     "GET",
+		URL:        "https://howdy",
+		Expiration: time.Now().Add(1 * time.Hour),
+	}
+	if err := r.Sign(c); err != nil {
+		t.Fatalf("Failed to sign. %v", err)
+	}
+	if r.Algorithm != AlgorithmRSASHA256 {
+		t.Fatalf("Expected Sign to default the algorithm, got %v", r.Algorithm)
+	}
+
+	req, err := r.HTTPRequest(nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request. %v", err)
+	}
+	req.Header.Set("Signature-Algorithm", "RSA-SHA512")
+	r2, err := ParseHTTPRequest(req)
+	if err != nil {
+		t.Fatalf("Failed to parse HTTP request. %v", err)
+	}
+	if err := r2.Verify(c); err != ErrUnknownAlgorithm {
+		t.Fatalf("Expected ErrUnknownAlgorithm but got %v", err)
+	}
+
+	// Dropping the algorithm changes the signing string.
+	req.Header.Del("Signature-Algorithm")
+	r2, err = ParseHTTPRequest(req)
+	if err != nil {
+		t.Fatalf("Failed to parse HTTP request. %v", err)
+	}
+	if err := r2.Verify(c); err == nil {
+		t.Fatal("Expected verification without the algorithm header to fail")
+	}
+}
+
+func TestCanonicalURL(t *testing.T) {
+	tests := []struct {
+		url       string
+		canonical string
+	}{
+		{"https://howdy", "https://howdy"},
+		{"/Path/To", "/Path/To"},
+		{"https://howdy/p?b=2&a=1", "https://howdy/p?a=1&b=2"},
+		{"https://howdy/p?a=2&b=3&a=1", "https://howdy/p?a=1&a=2&b=3"},
+		{"/p?x=%2F&c", "/p?c=&x=%2F"},
+		{"HTTPS://Howdy:443/Path", "https://howdy/Path"},
+		{"http://howdy:80/", "http://howdy/"},
+		{"http://howdy:443/", "http://howdy:443/"},
+		{"https://howdy:8443/", "https://howdy:8443/"},
+		{"https://howdy//a/./b/../c/", "https://howdy/a/c/"},
+		{"https://howdy/a%2Fb/./c", "https://howdy/a%2Fb/c"},
+		{"https://howdy/p?b=2&x=%zz", "https://howdy/p?b=2&x=%zz"},
+	}
+	for _, test := range tests {
+		if c := canonicalURL(test.url); c != test.canonical {
+			t.Errorf("canonicalURL(%q): expected %q but got %q", test.url, test.canonical, c)
+		}
+	}
+
+	// Pairs that don't parse must not be dropped from what is signed.
+	for _, extra := range []string{"https://howdy/p?x=%zz&b=2", "https://howdy/p?a=1;admin=1&b=2"} {
+		if canonicalURL(extra) == canonicalURL("https://howdy/p?b=2") {
+			t.Errorf("canonicalURL(%q): expected the unparsed pairs to be kept", extra)
+		}
+	}
+}
+
+func TestShuffledQueryVerifies(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	r := &SignedRequest{
+		Method:     "GET",
+		URL:        "https://howdy/p?b=2&a=1&a=0",
+		Expiration: time.Now().Add(1 * time.Hour),
+	}
+	if err := r.Sign(c); err != nil {
+		t.Fatalf("Failed to sign. %v", err)
+	}
+	for _, u := range []string{"https://howdy/p?a=0&b=2&a=1", "https://howdy/p?a=1&a=0&b=2"} {
+		r.URL = u
+		if err := r.Verify(c); err != nil {
+			t.Errorf("Expected %v to verify. %v", u, err)
+		}
+	}
+	r.URL = "https://howdy/p?a=0&b=2&a=2"
+	if err := r.Verify(c); err == nil {
+		t.Error("Expected a changed query value to fail verification")
+	}
+}
+
+func TestMarshalSignedRequestStable(t *testing.T) {
+	exp := time.Unix(1500000000, 0)
+	r1 := &SignedRequest{
+		Method:     "GET",
+		URL:        "https://howdy",
+		Expiration: exp.Add(250 * time.Millisecond),
+		Headers:    http.Header{"X-B": {"2"}, "X-A": {"1"}, "X-C": {"3"}},
+		Signature:  "sig",
+	}
+	r2 := &SignedRequest{
+		Method:     "GET",
+		URL:        "https://howdy",
+		Expiration: exp.In(time.FixedZone("test", 3600)),
+		Headers:    http.Header{"X-C": {"3"}, "X-A": {"1"}, "X-B": {"2"}},
+		Signature:  "sig",
+	}
+	b1, err := MarshalSignedRequest(r1)
+	if err != nil {
+		t.Fatalf("Failed to marshal. %v", err)
+	}
+	b2, err := MarshalSignedRequest(r2)
+	if err != nil {
+		t.Fatalf("Failed to marshal. %v", err)
+	}
+	if string(b1) != string(b2) {
+		t.Fatalf("Expected equal requests to marshal identically.\n%s\n%s", b1, b2)
+	}
+	if r1.signingString() != r2.signingString() {
+		t.Fatal("Expected equal requests to have the same signing string")
+	}
+}
+
+func TestMarshalSignedRequestRoundTrip(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	r := &SignedRequest{
+		Method:     "PUT",
+		URL:        "https://howdy/upload?b=2&a=1",
+		Expiration: time.Now().Add(1 * time.Hour),
+		Headers:    http.Header{"Content-Type": {"text/plain"}},
+	}
+	if err := r.Sign(c); err != nil {
+		t.Fatalf("Failed to sign. %v", err)
+	}
+	b, err := MarshalSignedRequest(r)
+	if err != nil {
+		t.Fatalf("Failed to marshal. %v", err)
+	}
+	r2, err := UnmarshalSignedRequest(b)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal. %v", err)
+	}
+	if err := r2.Verify(c); err != nil {
+		t.Fatalf("Expected unmarshaled request to verify. %v", err)
+	}
+}
+
+func TestSignerMaxTTL(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	s := &Signer{
+		MaxTTL: 1 * time.Hour,
+		Now:    func() time.Time { return now },
+	}
+	r := &SignedRequest{
+		Method:     "GET",
+		URL:        "https://howdy",
+		Expiration: now.Add(365 * 24 * time.Hour),
+	}
+	// Rejected before any signing happens, so no App Engine context is needed.
+	if err := s.Sign(context.Background(), r); err != ErrTTLTooLong {
+		t.Fatalf("Expected ErrTTLTooLong but got %v", err)
+	}
+	if r.Signature != "" {
+		t.Fatal("Expected no signature to be set")
+	}
+}
+
+func TestParseHTTPRequestMismatch(t *testing.T) {
+	r := &SignedRequest{
+		Method:     "PUT",
+		URL:        "https://howdy/a?x=1&y=2",
+		Expiration: time.Now().Add(1 * time.Hour),
+		Signature:  "sig",
+	}
+	newRequest := func() *http.Request {
+		req, err := r.HTTPRequest(nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request. %v", err)
+		}
+		return req
+	}
+
+	r2, err := ParseHTTPRequest(newRequest())
+	if err != nil {
+		t.Fatalf("Failed to parse HTTP request. %v", err)
+	}
+	if r2.Method != r.Method || r2.URL != r.URL {
+		t.Fatalf("Expected signed method and URL, got %v %v", r2.Method, r2.URL)
+	}
+
+	req := newRequest()
+	req.Method = "POST"
+	if _, err := ParseHTTPRequest(req); err != ErrRequestMismatch {
+		t.Errorf("Expected ErrRequestMismatch for a different method but got %v", err)
+	}
+
+	req = newRequest()
+	req.URL.Path = "/b"
+	if _, err := ParseHTTPRequest(req); err != ErrRequestMismatch {
+		t.Errorf("Expected ErrRequestMismatch for a different path but got %v", err)
+	}
+
+	req = newRequest()
+	req.URL.RawQuery = "x=1&y=3"
+	if _, err := ParseHTTPRequest(req); err != ErrRequestMismatch {
+		t.Errorf("Expected ErrRequestMismatch for a different query but got %v", err)
+	}
+
+	// A server sees only the path and reordered query parameters are fine.
+	req = newRequest()
+	req.URL, _ = req.URL.Parse("/a?y=2&x=1")
+	if _, err := ParseHTTPRequest(req); err != nil {
+		t.Errorf("Expected a server side request to parse. %v", err)
+	}
+
+	// A server sees the host in the Host header, possibly with a default
+	// port or in a different case.
+	for _, host := range []string{"howdy", "HOWDY:443"} {
+		req = newRequest()
+		req.URL, _ = req.URL.Parse("/a?x=1&y=2")
+		req.Host = host
+		if _, err := ParseHTTPRequest(req); err != nil {
+			t.Errorf("Expected a request with Host %v to parse. %v", host, err)
+		}
+	}
+
+	// A request signed for one host can't be replayed against another.
+	for _, host := range []string{"other", "howdy.example.com", "howdy:8443"} {
+		req = newRequest()
+		req.URL, _ = req.URL.Parse("/a?x=1&y=2")
+		req.Host = host
+		if _, err := ParseHTTPRequest(req); err != ErrRequestMismatch {
+			t.Errorf("Expected ErrRequestMismatch for Host %q but got %v", host, err)
+		}
+	}
+
+	// A URL without a path arrives at a server with the path "/".
+	for _, signedURL := range []string{"https://howdy", "https://howdy?x=1"} {
+		r := &SignedRequest{
+			Method:     "GET",
+			URL:        signedURL,
+			Expiration: time.Now().Add(1 * time.Hour),
+			Signature:  "sig",
+		}
+		sent, err := r.HTTPRequest(nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request. %v", err)
+		}
+		req := httptest.NewRequest(sent.Method, sent.URL.RequestURI(), nil)
+		req.Host = "howdy"
+		req.Header = sent.Header
+		if req.URL.Path != "/" {
+			t.Fatalf("Expected the server to see path /, got %q", req.URL.Path)
+		}
+		r2, err := ParseHTTPRequest(req)
+		if err != nil {
+			t.Errorf("Expected a request signed for %v to parse. %v", signedURL, err)
+		} else if r2.URL != signedURL {
+			t.Errorf("Expected signed URL %v, got %v", signedURL, r2.URL)
+		}
+	}
+}
+
+func TestClaims(t *testing.T) {
+	key := newRSAKey(t, "test-key")
+	s := &Signer{Key: key}
+	v := &Verifier{Key: key}
+	c := context.Background()
+
+	r := &SignedRequest{
+		Method:     "GET",
+		URL:        "https://howdy/files",
+		Expiration: time.Now().Add(1 * time.Hour),
+		Claims:     map[string]string{"user": "alice", "scope": "read&write=all"},
+	}
+	if err := s.Sign(c, r); err != nil {
+		t.Fatalf("Failed to sign. %v", err)
+	}
+	req, err := r.HTTPRequest(nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request. %v", err)
+	}
+	r2, err := ParseHTTPRequest(req)
+	if err != nil {
+		t.Fatalf("Failed to parse HTTP request. %v", err)
+	}
+	if err := v.Verify(c, r2); err != nil {
+		t.Fatalf("Expected signed request to verify. %v", err)
+	}
+	if !reflect.DeepEqual(r2.Claims, r.Claims) {
+		t.Fatalf("Expected claims %v but got %v", r.Claims, r2.Claims)
+	}
+
+	tampered := []string{
+		"scope=read%26write%3Dall&user=mallory",
+		"scope=read&user=alice&write=all",
+		"scope=read%26write%3Dall&user=alice&admin=true",
+		"",
+	}
+	for _, claims := range tampered {
+		req.Header.Set("Signature-Claims", claims)
+		r2, err := ParseHTTPRequest(req)
+		if err != nil {
+			t.Fatalf("Failed to parse HTTP request. %v", err)
+		}
+		if err := v.Verify(c, r2); err != ErrBadSignature {
+			t.Errorf("Expected claims %q to fail with ErrBadSignature but got %v", claims, err)
+		}
+	}
+}
+
+func TestSignedHeadersNormalized(t *testing.T) {
+	key := newRSAKey(t, "test-key")
+	c := context.Background()
+
+	r := &SignedRequest{
+		Method:     "PUT",
+		URL:        "https://howdy/upload",
+		Expiration: time.Now().Add(1 * time.Hour),
+		Headers:    http.Header{"Content-Type": {"image/png"}, "X-Owner": {"alice"}},
+	}
+	if err := (&Signer{Key: key}).Sign(c, r); err != nil {
+		t.Fatalf("Failed to sign. %v", err)
+	}
+
+	tests := [][]string{
+		{"content-type", "X-OWNER"},
+		{"Content-Type", "content-type", "X-Owner"},
+		{"x-owner, CONTENT-TYPE", "Content-Type"},
+	}
+	for _, signedHeaders := range tests {
+		req, err := r.HTTPRequest(nil)
+		if err != nil {
+			t.Fatalf("Failed to create HTTP request. %v", err)
+		}
+		req.Header["Signed-Headers"] = signedHeaders
+		r2, err := ParseHTTPRequest(req)
+		if err != nil {
+			t.Fatalf("Failed to parse HTTP request. %v", err)
+		}
+		if err := (&Verifier{Key: key}).Verify(c, r2); err != nil {
+			t.Errorf("Signed-Headers %q: expected signed request to verify. %v", signedHeaders, err)
+		}
 	}
 }
@@ -0,0 +1,121 @@
+package signedrequest
+
+import (
+	"errors"
+	"github.com/drichardson/appengine/signature"
+	"golang.org/x/net/context"
+	"net/http"
+	"time"
+)
+
+// Error code that indicates the request expiration is further out than
+// the Signer allows.
+var ErrTTLTooLong = errors.New("ErrTTLTooLong")
+
+// Error code that indicates a header that must be signed is missing.
+var ErrMissingRequiredHeader = errors.New("ErrMissingRequiredHeader")
+
+// Signer signs requests according to a configurable policy.
+// The zero value is ready to use and is what SignedRequest.Sign uses.
+type Signer struct {
+	// MaxTTL is the longest a signed request may be valid for, measured
+	// from the time it is signed. Zero means there is no limit.
+	MaxTTL time.Duration
+
+	// Now returns the current time. If nil, time.Now is used.
+	Now func() time.Time
+
+	// Key signs requests. If nil, appengine.SignBytes is used.
+	Key KeySigner
+
+	// RequiredHeaders lists headers that must be present in Headers, such as
+	// Content-Type. Sign fails with ErrMissingRequiredHeader if one is absent.
+	RequiredHeaders []string
+}
+
+// Sign signs the parameters of p and sets its Signature field.
+// If Key is nil, c must be an App Engine context created with appengine.NewContext.
+// Method, URL, and Expiration must be set.
+func (s *Signer) Sign(c context.Context, p *SignedRequest) error {
+	if p.Algorithm == "" {
+		p.Algorithm = AlgorithmRSASHA256
+	}
+	keyName, sig, err := s.sign(c, p)
+	if err != nil {
+		return err
+	}
+	p.Signature = sig
+	p.KeyName = keyName
+	return nil
+}
+
+func (s *Signer) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func (s *Signer) key() KeySigner {
+	if s.Key != nil {
+		return s.Key
+	}
+	return appEngineKey{}
+}
+
+// CoSign adds a signature by s's key to p.Signatures, keeping any existing
+// signatures, so that several keys can sign the same request. Every
+// signature covers the same fields, so they must not change once p has been
+// signed. Use a Verifier with RequireAll or RequireQuorum to require
+// signatures from more than one key.
+func (s *Signer) CoSign(c context.Context, p *SignedRequest) error {
+	if p.Algorithm == "" && len(p.signatures()) == 0 {
+		p.Algorithm = AlgorithmRSASHA256
+	}
+	keyName, sig, err := s.sign(c, p)
+	if err != nil {
+		return err
+	}
+	p.Signatures = append(p.Signatures, KeySignature{KeyName: keyName, Signature: sig})
+	return nil
+}
+
+// sign checks p against the policy and returns its base64 encoded signature
+// and the name of the key that made it.
+func (s *Signer) sign(c context.Context, p *SignedRequest) (string, string, error) {
+	if err := p.validate(); err != nil {
+		return "", "", err
+	}
+	if s.MaxTTL > 0 && p.Expiration.After(s.now().Add(s.MaxTTL)) {
+		return "", "", ErrTTLTooLong
+	}
+	if !hasHeaders(p.Headers, s.RequiredHeaders) {
+		return "", "", ErrMissingRequiredHeader
+	}
+	if p.Algorithm != "" && p.Algorithm != AlgorithmRSASHA256 {
+		return "", "", ErrUnknownAlgorithm
+	}
+	keyName, sig, err := s.key().SignBytes(c, []byte(p.signingString()))
+	if err != nil {
+		return "", "", err
+	}
+	return keyName, signature.EncodeBase64(sig), nil
+}
+
+// hasHeaders reports whether h has a value for every name in names,
+// regardless of how the keys of h are cased.
+func hasHeaders(h http.Header, names []string) bool {
+	for _, name := range names {
+		found := false
+		for k := range h {
+			if http.CanonicalHeaderKey(k) == http.CanonicalHeaderKey(name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+package signedrequest
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// signatureParams are the headers set by HTTPRequest that ToSignedURL puts in
+// the query instead. Signature-URL isn't needed, since it's the URL itself.
+var signatureParams = []string{
+	"Signature",
+	"Signature-Expiration",
+	"Signature-Method",
+	"Signature-Key-Name",
+	"Signature-Algorithm",
+	"Signature-Body-Hash",
+	"Signature-Nonce",
+	"Signature-Claims",
+	"Signature-Bound-To",
+	"Signature-Cosignature",
+	"Signed-Headers",
+}
+
+// originParam holds the scheme and host of an absolute signed URL, since a
+// server usually only sees the path and query.
+const originParam = "Signature-Origin"
+
+// ToSignedURL returns p's URL with its signature and expiration in query
+// parameters instead of headers, so that it can be followed as a link, for
+// instance by a browser. p must be signed, usually with Method GET. Any signed
+// Headers must still be sent as headers, which a plain link can't do.
+// Handler accepts requests signed either way; see also FromSignedURL.
+func (p *SignedRequest) ToSignedURL() (string, error) {
+	if len(p.signatures()) == 0 {
+		return "", ErrMalformedSignature
+	}
+	r, err := p.HTTPRequest(nil)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return "", err
+	}
+	q, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return "", err
+	}
+	for _, name := range signatureParams {
+		if vals := r.Header[name]; len(vals) > 0 {
+			q[name] = vals
+		}
+	}
+	if u.IsAbs() {
+		q.Set(originParam, u.Scheme+"://"+u.Host)
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// FromSignedURL parses the SignedRequest from a URL made by ToSignedURL. The
+// signed method is taken from the URL. rawurl may be just the path and
+// query, as a server sees it, in which case the host is taken from the
+// signed origin.
+func FromSignedURL(rawurl string) (*SignedRequest, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	r := &http.Request{
+		Method: q.Get("Signature-Method"),
+		URL:    u,
+		Host:   u.Host,
+		Header: make(http.Header),
+	}
+	if r.Host == "" {
+		if origin, err := url.Parse(q.Get(originParam)); err == nil {
+			r.Host = origin.Host
+		}
+	}
+	return parseSignedURL(r)
+}
+
+// isSignedURL reports whether r carries its signature in the query, as made
+// by ToSignedURL, rather than in headers.
+func isSignedURL(r *http.Request) bool {
+	return r.Header.Get("Signature") == "" && r.URL.Query().Get("Signature") != ""
+}
+
+// parseSignedURL is like ParseHTTPRequest for requests to URLs made by
+// ToSignedURL. The signature parameters are moved from the query to headers,
+// and the remaining URL is what was signed. The signed origin's host must
+// match r's, so a link can't be replayed against another host.
+func parseSignedURL(r *http.Request) (*SignedRequest, error) {
+	h := make(http.Header)
+	for k, vals := range r.Header {
+		h[k] = vals
+	}
+	// Re-encoding a query that doesn't parse would drop pairs the
+	// application could still read, so such URLs are rejected.
+	q, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range signatureParams {
+		h.Del(name)
+	}
+	h.Del("Signature-URL")
+	for _, name := range signatureParams {
+		if vals, ok := q[name]; ok {
+			h[name] = vals
+			q.Del(name)
+		}
+	}
+	origin := q.Get(originParam)
+	q.Del(originParam)
+
+	u := *r.URL
+	u.Scheme, u.Host, u.RawQuery = "", "", q.Encode()
+	signedURL := u.String()
+	if origin != "" {
+		signedURL = origin + signedURL
+	}
+	h.Set("Signature-URL", signedURL)
+
+	return ParseHTTPRequest(&http.Request{Method: r.Method, URL: &u, Host: requestHost(r), Header: h})
+}
@@ -0,0 +1,97 @@
+package signedrequest
+
+import (
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedURLRoundTrip(t *testing.T) {
+	key := newRSAKey(t, "test-key")
+	c := context.Background()
+
+	r := &SignedRequest{
+		Method:     "GET",
+		URL:        "https://example.com/download?file=a%20b.pdf&x=1",
+		Expiration: time.Now().Add(1 * time.Hour),
+		Claims:     map[string]string{"user": "alice"},
+	}
+	if _, err := r.ToSignedURL(); err != ErrMalformedSignature {
+		t.Fatalf("Expected ErrMalformedSignature before signing but got %v", err)
+	}
+	if err := (&Signer{Key: key}).Sign(c, r); err != nil {
+		t.Fatalf("Failed to sign. %v", err)
+	}
+	link, err := r.ToSignedURL()
+	if err != nil {
+		t.Fatalf("Failed to make signed URL. %v", err)
+	}
+	if !strings.HasPrefix(link, "https://example.com/download?") {
+		t.Fatalf("Unexpected signed URL %v", link)
+	}
+
+	r2, err := FromSignedURL(link)
+	if err != nil {
+		t.Fatalf("Failed to parse signed URL. %v", err)
+	}
+	if _, err := FromSignedURL(strings.TrimPrefix(link, "https://example.com")); err != nil {
+		t.Fatalf("Failed to parse relative signed URL. %v", err)
+	}
+	if _, err := FromSignedURL(strings.Replace(link, "example.com", "evil.example", 1)); err != ErrRequestMismatch {
+		t.Fatalf("Expected ErrRequestMismatch for another host but got %v", err)
+	}
+	if r2.Method != r.Method || canonicalURL(r2.URL) != canonicalURL(r.URL) || r2.Expiration.Unix() != r.Expiration.Unix() ||
+		r2.Signature != r.Signature || r2.KeyName != r.KeyName || r2.Claims["user"] != "alice" {
+		t.Fatalf("Expected %+v but got %+v", r, r2)
+	}
+	if err := (&Verifier{Key: key}).Verify(c, r2); err != nil {
+		t.Fatalf("Expected parsed request to verify. %v", err)
+	}
+
+	// The handler accepts the link, whether it sees the absolute URL or
+	// just the path and query, and rejects it if the query is changed.
+	handler := &Handler{
+		Func: func(w http.ResponseWriter, r *http.Request, sr *SignedRequest) {
+			w.WriteHeader(http.StatusOK)
+		},
+		Verifier: &Verifier{Key: key},
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.Replace(link, "x=1", "x=2", 1)
+	tests := []struct {
+		target string
+		code   int
+	}{
+		{link, http.StatusOK},
+		{u.RequestURI(), http.StatusOK},
+		{tampered, http.StatusUnauthorized},
+		{link + "&admin=%zz", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", test.target, nil))
+		if rr.Code != test.code {
+			t.Errorf("%v: expected %v, got %v", test.target, test.code, rr.Code)
+		}
+	}
+	// A link signed for one host is rejected when replayed against another.
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", u.RequestURI(), nil)
+	req.Host = "evil.example"
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a different host to be rejected with %v, got %v", http.StatusBadRequest, rr.Code)
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("DELETE", link, nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected a different method to be rejected with %v, got %v", http.StatusBadRequest, rr.Code)
+	}
+}
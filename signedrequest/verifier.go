@@ -0,0 +1,176 @@
+package signedrequest
+
+import (
+	"crypto/rsa"
+	"errors"
+	"github.com/drichardson/appengine/signature"
+	"golang.org/x/net/context"
+	"time"
+)
+
+// Error codes returned by Verify for signatures that can't be trusted.
+var (
+	// ErrMalformedSignature indicates the signature is missing or not valid base64.
+	ErrMalformedSignature = errors.New("ErrMalformedSignature")
+	// ErrBadSignature indicates the signature doesn't match the request.
+	ErrBadSignature = errors.New("ErrBadSignature")
+	// ErrExpirationTooFar indicates the request expires further in the
+	// future than the Verifier's MaxValidityWindow allows.
+	ErrExpirationTooFar = errors.New("ErrExpirationTooFar")
+	// ErrInsufficientSignatures indicates fewer signatures verified than
+	// the Verifier's Quorum requires.
+	ErrInsufficientSignatures = errors.New("ErrInsufficientSignatures")
+)
+
+// SignaturePolicy decides which of a request's signatures must verify.
+type SignaturePolicy int
+
+const (
+	// RequireAny accepts a request if any of its signatures verifies.
+	RequireAny SignaturePolicy = iota
+	// RequireAll accepts a request only if all of its signatures verify and
+	// they are from at least two keys, or Verifier.Quorum keys if that is more,
+	// so a single signature isn't enough.
+	RequireAll
+	// RequireQuorum accepts a request if signatures from at least
+	// Verifier.Quorum keys verify.
+	RequireQuorum
+)
+
+// Verifier verifies signed requests according to a configurable policy.
+// The zero value is ready to use and is what SignedRequest.Verify uses.
+type Verifier struct {
+	// Leeway is how long after its expiration a request is still accepted,
+	// to tolerate clock skew between the signing and verifying machines.
+	Leeway time.Duration
+
+	// Now returns the current time. If nil, time.Now is used. Tests can
+	// set it to freeze time.
+	Now func() time.Time
+
+	// Key verifies signatures. If nil, the App Engine public certificates are used.
+	// A Key should return ErrBadSignature or rsa.ErrVerification when a
+	// signature doesn't match.
+	Key KeyVerifier
+
+	// RequiredHeaders lists headers that must have been signed. Verify fails
+	// with ErrMissingRequiredHeader if one is absent, so a client can't drop
+	// a header from the Signed-Headers list.
+	RequiredHeaders []string
+
+	// MaxValidityWindow is the furthest in the future a request may expire,
+	// measured from the time it is verified, which catches signers minting
+	// near-permanent requests. Zero means there is no limit. See also
+	// Signer.MaxTTL.
+	MaxValidityWindow time.Duration
+
+	// Policy decides which of the request's Signature and Signatures must
+	// verify. The default is RequireAny.
+	Policy SignaturePolicy
+
+	// Quorum is the number of keys whose signatures RequireQuorum needs.
+	// Signatures are counted by the key that verified them, so repeating one
+	// key's signature doesn't add to the count. RequireAll and RequireQuorum
+	// reject signatures without a KeyName as malformed. Values below 1 are
+	// treated as 1.
+	Quorum int
+}
+
+// Verify verifies the signatures of p according to Policy. If Key is nil, c must be an appengine
+// context created with appengine.NewContext. Verify returns ErrMalformedSignature,
+// ErrBadSignature, ErrInsufficientSignatures, ErrUnknownAlgorithm, ErrMissingRequiredHeader,
+// ErrExpired, or ErrExpirationTooFar if the request can't be trusted. Any other error means
+// verification couldn't be completed, for instance because the public certificates couldn't be fetched.
+func (v *Verifier) Verify(c context.Context, p *SignedRequest) error {
+	// An empty Algorithm is accepted for requests signed before it was added.
+	if p.Algorithm != "" && p.Algorithm != AlgorithmRSASHA256 {
+		return ErrUnknownAlgorithm
+	}
+	sigs := p.signatures()
+	if len(sigs) == 0 {
+		return ErrMalformedSignature
+	}
+	if !hasHeaders(p.Headers, v.RequiredHeaders) {
+		return ErrMissingRequiredHeader
+	}
+	signingBytes := []byte(p.signingString())
+	verified := make(map[string]bool)
+	var failure error
+	for _, sig := range sigs {
+		keyName, err := v.verifySignature(c, signingBytes, sig)
+		switch err {
+		case nil:
+			verified[keyName] = true
+		case ErrMalformedSignature, ErrBadSignature:
+			if v.Policy == RequireAll {
+				return err
+			}
+			failure = err
+		default:
+			return err
+		}
+	}
+	if len(verified) == 0 {
+		return failure
+	}
+	if v.Policy != RequireAny && len(verified) < v.quorum() {
+		return ErrInsufficientSignatures
+	}
+	now := v.now()
+	if now.After(p.Expiration.Add(v.Leeway)) {
+		return ErrExpired
+	}
+	if v.MaxValidityWindow > 0 && p.Expiration.After(now.Add(v.MaxValidityWindow)) {
+		return ErrExpirationTooFar
+	}
+	return nil
+}
+
+// verifySignature verifies a single signature of signingBytes and returns
+// the name of the key that verified it.
+func (v *Verifier) verifySignature(c context.Context, signingBytes []byte, sig KeySignature) (string, error) {
+	// Without a KeyName, one key's signature could be counted under two
+	// names, so policies that count keys require one.
+	if sig.KeyName == "" && v.Policy != RequireAny {
+		return "", ErrMalformedSignature
+	}
+	b, err := signature.DecodeBase64(sig.Signature)
+	if err != nil || len(b) == 0 {
+		return "", ErrMalformedSignature
+	}
+	keyName, err := v.key().VerifyBytes(c, signingBytes, b, sig.KeyName)
+	switch err {
+	case nil:
+		return keyName, nil
+	case rsa.ErrVerification, signature.ErrKeyNotFound, ErrBadSignature:
+		return "", ErrBadSignature
+	default:
+		return "", err
+	}
+}
+
+// quorum returns the number of keys whose signatures Policy requires.
+func (v *Verifier) quorum() int {
+	q := v.Quorum
+	if v.Policy == RequireAll && q < 2 {
+		q = 2
+	}
+	if q < 1 {
+		q = 1
+	}
+	return q
+}
+
+func (v *Verifier) now() time.Time {
+	if v.Now != nil {
+		return v.Now()
+	}
+	return time.Now()
+}
+
+func (v *Verifier) key() KeyVerifier {
+	if v.Key != nil {
+		return v.Key
+	}
+	return appEngineKey{}
+}
@@ -0,0 +1,161 @@
+package signedrequest
+
+import (
+	"golang.org/x/net/context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// keyRing verifies signatures from any of its keys, selected by key name.
+// Like the App Engine certificates, it tries every key if the name is empty.
+type keyRing []*rsaKey
+
+func (ring keyRing) VerifyBytes(c context.Context, bytes []byte, sig []byte, keyName string) (string, error) {
+	for _, k := range ring {
+		if keyName == "" || k.name == keyName {
+			if name, err := k.VerifyBytes(c, bytes, sig, keyName); err == nil || keyName != "" {
+				return name, err
+			}
+		}
+	}
+	return "", ErrBadSignature
+}
+
+// reencode returns another base64 encoding of the same bytes as sig, which
+// must end in padding. The last character before the padding has unused
+// bits, which lenient decoding ignores.
+func reencode(sig string) string {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	data := strings.TrimRight(sig, "=")
+	i := len(data) - 1
+	return data[:i] + string(alphabet[strings.IndexByte(alphabet, data[i])^1]) + sig[len(data):]
+}
+
+func TestCoSign(t *testing.T) {
+	a := newRSAKey(t, "service-a")
+	b := newRSAKey(t, "service-b")
+	ring := keyRing{a, b}
+	c := context.Background()
+
+	r := &SignedRequest{
+		Method:     "DELETE",
+		URL:        "https://howdy/accounts/1",
+		Expiration: time.Now().Add(1 * time.Hour),
+	}
+	if err := (&Signer{Key: a}).Sign(c, r); err != nil {
+		t.Fatalf("Failed to sign. %v", err)
+	}
+	if err := (&Signer{Key: b}).CoSign(c, r); err != nil {
+		t.Fatalf("Failed to co-sign. %v", err)
+	}
+	req, err := r.HTTPRequest(nil)
+	if err != nil {
+		t.Fatalf("Failed to create HTTP request. %v", err)
+	}
+	r2, err := ParseHTTPRequest(req)
+	if err != nil {
+		t.Fatalf("Failed to parse HTTP request. %v", err)
+	}
+	if len(r2.Signatures) != 1 || r2.Signatures[0] != r.Signatures[0] {
+		t.Fatalf("Expected co-signature %v but got %v", r.Signatures, r2.Signatures)
+	}
+
+	all := &Verifier{Key: ring, Policy: RequireAll}
+	quorum := &Verifier{Key: ring, Policy: RequireQuorum, Quorum: 2}
+	if err := all.Verify(c, r2); err != nil {
+		t.Fatalf("Expected both signatures to satisfy RequireAll. %v", err)
+	}
+	if err := quorum.Verify(c, r2); err != nil {
+		t.Fatalf("Expected both signatures to satisfy a quorum of 2. %v", err)
+	}
+
+	// Drop one of the signatures.
+	single := *r2
+	single.Signatures = nil
+	if err := quorum.Verify(c, &single); err != ErrInsufficientSignatures {
+		t.Fatalf("Expected ErrInsufficientSignatures but got %v", err)
+	}
+
+	// A single signature isn't enough for RequireAll.
+	if err := all.Verify(c, &single); err != ErrInsufficientSignatures {
+		t.Fatalf("Expected a single signature to fail RequireAll with ErrInsufficientSignatures but got %v", err)
+	}
+
+	// Repeating a signature doesn't count towards the quorum.
+	single.Signatures = []KeySignature{{KeyName: r2.KeyName, Signature: r2.Signature}}
+	if err := quorum.Verify(c, &single); err != ErrInsufficientSignatures {
+		t.Fatalf("Expected a repeated signature to fail with ErrInsufficientSignatures but got %v", err)
+	}
+	if err := all.Verify(c, &single); err != ErrInsufficientSignatures {
+		t.Fatalf("Expected a repeated signature to fail RequireAll with ErrInsufficientSignatures but got %v", err)
+	}
+
+	// Nor does repeating it without a KeyName, which a verifier that tries
+	// every key would otherwise accept as a second key's signature.
+	single.Signatures = []KeySignature{{Signature: r2.Signature}}
+	if err := quorum.Verify(c, &single); err != ErrInsufficientSignatures {
+		t.Fatalf("Expected an unnamed repeat to fail the quorum with ErrInsufficientSignatures but got %v", err)
+	}
+	if err := all.Verify(c, &single); err != ErrMalformedSignature {
+		t.Fatalf("Expected an unnamed signature to fail RequireAll with ErrMalformedSignature but got %v", err)
+	}
+	if err := (&Verifier{Key: ring}).Verify(c, &single); err != nil {
+		t.Fatalf("Expected RequireAny to accept an unnamed signature. %v", err)
+	}
+
+	// Nor does another encoding of it.
+	reencoded := reencode(r2.Signature)
+	single.Signatures = []KeySignature{{KeyName: r2.KeyName, Signature: reencoded}}
+	if err := quorum.Verify(c, &single); err != ErrMalformedSignature && err != ErrInsufficientSignatures {
+		t.Fatalf("Expected a re-encoded signature to fail the quorum but got %v", err)
+	}
+	single.Signature = reencoded
+	single.Signatures = nil
+	if err := (&Verifier{Key: ring}).Verify(c, &single); err != ErrMalformedSignature {
+		t.Fatalf("Expected a non-canonical encoding to fail with ErrMalformedSignature but got %v", err)
+	}
+
+	// Forge the co-signature.
+	forged := *r2
+	forged.Signatures = []KeySignature{{KeyName: "service-b", Signature: r2.Signature}}
+	if err := all.Verify(c, &forged); err != ErrBadSignature {
+		t.Fatalf("Expected ErrBadSignature but got %v", err)
+	}
+	if err := (&Verifier{Key: ring}).Verify(c, &forged); err != nil {
+		t.Fatalf("Expected RequireAny to accept one valid signature. %v", err)
+	}
+}
+
+func TestMaxValidityWindow(t *testing.T) {
+	key := newRSAKey(t, "test-key")
+	now := time.Unix(1500000000, 0)
+	v := &Verifier{
+		Key:               key,
+		Now:               func() time.Time { return now },
+		MaxValidityWindow: 24 * time.Hour,
+	}
+	c := context.Background()
+
+	tests := []struct {
+		expiration time.Time
+		err        error
+	}{
+		{now.Add(1 * time.Hour), nil},
+		{now.Add(24 * time.Hour), nil},
+		{now.Add(100 * 365 * 24 * time.Hour), ErrExpirationTooFar},
+	}
+	for _, test := range tests {
+		r := &SignedRequest{
+			Method:     "GET",
+			URL:        "https://howdy",
+			Expiration: test.expiration,
+		}
+		if err := (&Signer{Key: key}).Sign(c, r); err != nil {
+			t.Fatalf("Failed to sign. %v", err)
+		}
+		if err := v.Verify(c, r); err != test.err {
+			t.Errorf("Expiration %v: expected %v but got %v", test.expiration, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"golang.org/x/net/context"
+	"time"
+)
+
+// Bucket identifies a bucket in Google Cloud Storage and is suitable for
+// sending via JSON.
+type Bucket struct {
+	Name string `json:"name"`
+}
+
+// Object returns the BucketObject for the named object in this bucket.
+func (b *Bucket) Object(name string) *BucketObject {
+	return &BucketObject{Bucket: b.Name, Object: name}
+}
+
+// BatchSignedGetURLs makes a signed GET URL, like BucketObject.SignedGetURL, for
+// each of the named objects in this bucket. The URLs are returned in the same
+// order as objects. The service account is only looked up once.
+// ttl (time to live) is the duration the signed URLs are valid for.
+func (b *Bucket) BatchSignedGetURLs(c context.Context, objects []string, ttl time.Duration) ([]string, error) {
+	return b.BatchSignedGetURLsWithSigner(c, AppEngineSigner{}, objects, ttl)
+}
+
+// BatchSignedGetURLsWithSigner is like BatchSignedGetURLs, but the URLs are
+// signed by signer, such as an IAMSigner, instead of the app's service account.
+func (b *Bucket) BatchSignedGetURLsWithSigner(c context.Context, signer Signer, objects []string, ttl time.Duration) ([]string, error) {
+	sa, err := signer.ServiceAccount(c)
+	if err != nil {
+		return nil, err
+	}
+	urls := make([]string, len(objects))
+	for i, name := range objects {
+		urls[i], err = b.Object(name).SignedURL(c, &SignedURLOptions{
+			Method:         "GET",
+			TTL:            ttl,
+			GoogleAccessID: sa,
+			Signer:         signer,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return urls, nil
+}
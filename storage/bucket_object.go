@@ -2,12 +2,13 @@
 package storage
 
 import (
-	"encoding/base64"
-	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/drichardson/appengine/signature"
 	"golang.org/x/net/context"
-	"google.golang.org/appengine"
+	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -18,65 +19,508 @@ import (
 type BucketObject struct {
 	Bucket string `json:"bucket"`
 	Object string `json:"object"`
+	// Generation is optional. If set, URLs refer to that generation of the
+	// object in a versioned bucket, instead of the live version.
+	//
+	// Only V4 signs the generation. V2 sends it as an unsigned query
+	// parameter, so anyone with a V2 URL can change or remove it to access
+	// any generation of the object, including the live one. Use V4 when the
+	// URL must be limited to one generation.
+	Generation int64 `json:"generation,omitempty"`
 }
 
 // PublicGetURL returns an HTTPS URL that can reference the given object name in this
 // bucket. Note: this only works if the object is publicly readable.
 func (bo *BucketObject) PublicGetURL() string {
-	return "https://storage.googleapis.com/" + bo.Bucket + "/" + url.QueryEscape(bo.Object)
+	u := defaultHost + bo.resource()
+	if bo.Generation != 0 {
+		u += "?generation=" + strconv.FormatInt(bo.Generation, 10)
+	}
+	return u
+}
+
+// resource returns the escaped path of the object, /bucket/objectname.
+func (bo *BucketObject) resource() string {
+	return "/" + escapePath(bo.Bucket) + "/" + escapePath(bo.Object)
+}
+
+// escapePath percent encodes every byte of s other than "/" and the URI
+// unreserved characters, so object names with "/" keep their folder-like
+// structure while spaces and non-ASCII characters are escaped the way GCS
+// expects in both the URL and the string to sign.
+func escapePath(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || '0' <= ch && ch <= '9' ||
+			ch == '-' || ch == '.' || ch == '_' || ch == '~' || ch == '/' {
+			b.WriteByte(ch)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[ch>>4])
+		b.WriteByte(hex[ch&15])
+	}
+	return b.String()
 }
 
 // SignedPutURL makes a URL which can be used to upload content to Google Cloud Storage
 // by anyone with the URL.
 // name is the name of the google cloud storage object
-// contentType is the MIME of the content you can upload with the returned URL.
-// contentMD5 is the an MD5 digest of the content you can upload with the returned URL.
+// contentType is the MIME of the content you can upload with the returned URL, or
+// empty to allow any Content-Type (see SignedPutURLAnyContent).
+// contentMD5 is the hex encoded MD5 digest of the content you can upload with the
+// returned URL (see MD5Hex), or empty to allow any content. The client must send the
+// same digest, base64 encoded, in the Content-MD5 header (see ContentMD5Header).
 // ttl (time to live) is the duration the signed URL is valid for.
 func (bo *BucketObject) SignedPutURL(c context.Context, contentType, contentMD5 string, ttl time.Duration) (string, error) {
-	md5, err := hex.DecodeString(contentMD5)
+	return bo.SignedURL(c, &SignedURLOptions{
+		Method:      "PUT",
+		TTL:         ttl,
+		ContentType: contentType,
+		ContentMD5:  contentMD5,
+	})
+}
+
+// SignedUploadDownloadURLs makes a SignedPutURL for uploading the object and
+// a SignedGetURL for downloading it afterwards, looking up the service account
+// once for both. Both TTLs are measured from now, so downloadTTL should be
+// longer than uploadTTL if the download URL must outlive the upload.
+func (bo *BucketObject) SignedUploadDownloadURLs(c context.Context, contentType, contentMD5 string, uploadTTL, downloadTTL time.Duration) (put, get string, err error) {
+	return bo.signedUploadDownloadURLs(c, AppEngineSigner{}, contentType, contentMD5, uploadTTL, downloadTTL)
+}
+
+func (bo *BucketObject) signedUploadDownloadURLs(c context.Context, signer Signer, contentType, contentMD5 string, uploadTTL, downloadTTL time.Duration) (put, get string, err error) {
+	googleAccessID, err := signer.ServiceAccount(c)
+	if err != nil {
+		return "", "", err
+	}
+	put, err = bo.SignedURL(c, &SignedURLOptions{
+		Method:         "PUT",
+		TTL:            uploadTTL,
+		ContentType:    contentType,
+		ContentMD5:     contentMD5,
+		GoogleAccessID: googleAccessID,
+		Signer:         signer,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	get, err = bo.SignedURL(c, &SignedURLOptions{
+		Method:         "GET",
+		TTL:            downloadTTL,
+		GoogleAccessID: googleAccessID,
+		Signer:         signer,
+	})
 	if err != nil {
+		return "", "", err
+	}
+	return put, get, nil
+}
+
+// SignedPutURLAnyContent is like SignedPutURL, but neither the Content-Type nor
+// the content is bound by the signature, so the client's upload isn't rejected
+// because, say, its Content-Type has a different charset parameter. The cost is
+// that anyone with the URL can store any content of any type as the object
+// until the URL expires, including HTML or scripts that will be served with
+// the Content-Type the uploader chose, so only use it for objects that are never
+// served to browsers from a trusted origin, or validate objects after upload.
+// ttl (time to live) is the duration the signed URL is valid for.
+func (bo *BucketObject) SignedPutURLAnyContent(c context.Context, ttl time.Duration) (string, error) {
+	return bo.SignedURL(c, anyContentPutOptions(ttl))
+}
+
+func anyContentPutOptions(ttl time.Duration) *SignedURLOptions {
+	return &SignedURLOptions{
+		Method: "PUT",
+		TTL:    ttl,
+	}
+}
+
+// SignedPutURLWithHeaders is like SignedPutURL, but also requires the client
+// to send headers, such as Content-Encoding: gzip or Cache-Control, so the
+// object is stored with the intended metadata. The URL is signed with V4,
+// since V2 can't sign those headers, so ttl can be at most 7 days.
+func (bo *BucketObject) SignedPutURLWithHeaders(c context.Context, contentType, contentMD5 string, headers http.Header, ttl time.Duration) (string, error) {
+	return bo.SignedURL(c, &SignedURLOptions{
+		Method:      "PUT",
+		TTL:         ttl,
+		ContentType: contentType,
+		ContentMD5:  contentMD5,
+		Headers:     headers,
+		Version:     V4,
+	})
+}
+
+// SignedGetURL makes a URL which can be used to download the object from
+// Google Cloud Storage by anyone with the URL, even if the object is not
+// publicly readable.
+// ttl (time to live) is the duration the signed URL is valid for.
+func (bo *BucketObject) SignedGetURL(c context.Context, ttl time.Duration) (string, error) {
+	return bo.SignedURL(c, &SignedURLOptions{Method: "GET", TTL: ttl})
+}
+
+// SignedDeleteURL makes a URL which can be used to delete the object from
+// Google Cloud Storage by anyone with the URL.
+// ttl (time to live) is the duration the signed URL is valid for.
+func (bo *BucketObject) SignedDeleteURL(c context.Context, ttl time.Duration) (string, error) {
+	return bo.SignedURL(c, &SignedURLOptions{Method: "DELETE", TTL: ttl})
+}
+
+// SignedHeadURL makes a URL which can be used by anyone with the URL to get
+// the object's metadata, such as its size and ETag, with a HEAD request
+// without downloading it.
+// ttl (time to live) is the duration the signed URL is valid for.
+//
+// With V2, GCS accepts a URL signed for GET on a HEAD request, so the URL is
+// signed with the GET verb and can also be used to download the object. V4
+// signs the HEAD verb itself, so a V4 URL made with SignedURL and Method
+// HEAD can't be used to download the object.
+func (bo *BucketObject) SignedHeadURL(c context.Context, ttl time.Duration) (string, error) {
+	return bo.SignedURL(c, &SignedURLOptions{Method: "HEAD", TTL: ttl})
+}
+
+// signingVerb returns the HTTP verb that is signed for method.
+func signingVerb(method string, version SigningVersion) string {
+	if method == "HEAD" && version == V2 {
+		return "GET"
+	}
+	return method
+}
+
+// SignedResumableUploadURL makes a URL which can be used by anyone with the URL
+// to start a resumable upload of the object, for content too large to upload in
+// one request. The client:
+//
+//  1. POSTs to the URL with an empty body, the header x-goog-resumable: start,
+//     and the same Content-Type, if contentType is not empty.
+//  2. Reads the session URI from the Location header of the 201 response.
+//  3. PUTs the content to the session URI, in one or more chunks using
+//     Content-Range. The session URI needs no further signing.
+//
+// See https://cloud.google.com/storage/docs/access-control/signed-urls#signing-resumable
+// ttl (time to live) is the duration the signed URL is valid for. It only has
+// to cover starting the upload, not the upload itself.
+func (bo *BucketObject) SignedResumableUploadURL(c context.Context, contentType string, ttl time.Duration) (string, error) {
+	return bo.SignedURL(c, resumableUploadOptions(contentType, ttl))
+}
+
+func resumableUploadOptions(contentType string, ttl time.Duration) *SignedURLOptions {
+	return &SignedURLOptions{
+		Method:           "POST",
+		TTL:              ttl,
+		ContentType:      contentType,
+		ExtensionHeaders: http.Header{"X-Goog-Resumable": {"start"}},
+	}
+}
+
+// SigningVersion selects the scheme used to sign URLs.
+type SigningVersion int
+
+const (
+	// V2 is the legacy signing scheme. It is the default.
+	V2 SigningVersion = iota
+	// V4 is the GOOG4-RSA-SHA256 signing scheme, which Google recommends
+	// for new code. V4 URLs can be valid for at most 7 days.
+	// See https://cloud.google.com/storage/docs/access-control/signed-urls
+	V4
+)
+
+// SignedURLOptions are the parameters of a signed URL made by SignedURL.
+type SignedURLOptions struct {
+	// Method is the HTTP method the URL can be used with: GET, HEAD, PUT,
+	// POST, or DELETE.
+	Method string
+
+	// TTL (time to live) is the duration the signed URL is valid for.
+	TTL time.Duration
+
+	// ContentType is optional. If set, the client must send the same Content-Type.
+	ContentType string
+
+	// ContentMD5 is optional. It is the hex encoded MD5 digest of the content.
+	// If set, the client must send the same digest, base64 encoded, in Content-MD5.
+	ContentMD5 string
+
+	// Version is the signing scheme. The default is V2.
+	Version SigningVersion
+
+	// GoogleAccessID is the service account email address that the URL
+	// claims to be signed by. It must be the account whose key signs the URL.
+	// The default is Signer's ServiceAccount.
+	GoogleAccessID string
+
+	// Signer signs the URL. The default is AppEngineSigner.
+	Signer Signer
+
+	// ResponseContentDisposition is optional. If set, GCS responds to a GET
+	// with this Content-Disposition, e.g., `attachment; filename="report.pdf"`,
+	// instead of the one stored with the object.
+	ResponseContentDisposition string
+
+	// ResponseContentType is optional. If set, GCS responds to a GET with this
+	// Content-Type instead of the one stored with the object.
+	//
+	// The response overrides are sent as query parameters. V4 signs them as part
+	// of the canonical query string. V2 doesn't, because GCS leaves them out of
+	// the V2 string to sign.
+	ResponseContentType string
+
+	// ExtensionHeaders are optional x-goog- headers, such as x-goog-acl or
+	// x-goog-meta-*, that are signed into the URL. The client must send
+	// identical headers with the request.
+	ExtensionHeaders http.Header
+
+	// Headers are optional request headers, such as Cache-Control or
+	// Content-Encoding, that are signed into the URL so the object is stored
+	// with the intended metadata. The client must send identical headers with
+	// the request. V2 can only sign x-goog- headers, so other headers require V4.
+	Headers http.Header
+
+	// ExtraQuery holds optional query parameters, such as alt=media, that are
+	// signed into the URL's canonical query string. The client must send exactly
+	// these parameters. Only V4 signs the query string, so ExtraQuery requires
+	// V4, and X-Goog- parameters are reserved for the signature.
+	ExtraQuery url.Values
+
+	// Host is the scheme and host the URL points to, e.g., http://localhost:4443
+	// for a fake GCS server. The default is https://storage.googleapis.com.
+	// Only path style URLs (host/bucket/object) are supported. V2 doesn't sign the
+	// host, so a V2 signature is the same for any Host. V4 signs the host header.
+	Host string
+}
+
+// The default scheme and host of signed URLs.
+const defaultHost = "https://storage.googleapis.com"
+
+// Error code that indicates a header in ExtensionHeaders isn't an x-goog- header.
+var ErrNotExtensionHeader = errors.New("ErrNotExtensionHeader")
+
+// Error code that indicates a header in Headers can only be signed with V4.
+var ErrHeaderRequiresV4 = errors.New("ErrHeaderRequiresV4")
+
+// Error code that indicates ExtraQuery is used without V4.
+var ErrQueryRequiresV4 = errors.New("ErrQueryRequiresV4")
+
+// Error code that indicates a parameter in ExtraQuery is reserved for the V4
+// signature.
+var ErrReservedQueryParameter = errors.New("ErrReservedQueryParameter")
+
+// Error codes returned for invalid signed URL parameters.
+var (
+	ErrInvalidTTL        = errors.New("ErrInvalidTTL")
+	ErrEmptyBucket       = errors.New("ErrEmptyBucket")
+	ErrEmptyObject       = errors.New("ErrEmptyObject")
+	ErrInvalidContentMD5 = errors.New("ErrInvalidContentMD5")
+	ErrNilOptions        = errors.New("ErrNilOptions")
+	ErrInvalidMethod     = errors.New("ErrInvalidMethod")
+)
+
+// validMethod reports whether method can be signed. Methods are case
+// sensitive, so Cloud Storage wouldn't accept a request for a lowercase one.
+func validMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "POST", "DELETE":
+		return true
+	}
+	return false
+}
+
+// validate checks that bo names an object and ttl is at least a second,
+// since expirations are in whole seconds.
+func (bo *BucketObject) validate(ttl time.Duration) error {
+	if bo.Bucket == "" {
+		return ErrEmptyBucket
+	}
+	if bo.Object == "" {
+		return ErrEmptyObject
+	}
+	if ttl < time.Second {
+		return ErrInvalidTTL
+	}
+	return nil
+}
+
+// SignedURL makes a URL which can be used by anyone with the URL to access
+// the object as described by opts.
+func (bo *BucketObject) SignedURL(c context.Context, opts *SignedURLOptions) (string, error) {
+	if opts == nil {
+		return "", ErrNilOptions
+	}
+	if !validMethod(opts.Method) {
+		return "", ErrInvalidMethod
+	}
+	if err := bo.validate(opts.TTL); err != nil {
 		return "", err
 	}
-	contentMD5Base64 := base64.StdEncoding.EncodeToString(md5)
+	var contentMD5Base64 string
+	if opts.ContentMD5 != "" {
+		var err error
+		contentMD5Base64, err = ContentMD5Header(opts.ContentMD5)
+		if err != nil {
+			return "", err
+		}
+	}
 
-	host := "https://storage.googleapis.com"
-	resource := "/" + bo.Bucket + "/" + bo.Object
-	expiry := time.Now().Add(ttl)
-	return generateSignedURLs(c, host, resource, expiry, "PUT", contentMD5Base64, contentType)
+	headers := make(http.Header)
+	for name, vals := range opts.ExtensionHeaders {
+		if !isExtensionHeader(name) {
+			return "", ErrNotExtensionHeader
+		}
+		headers[name] = append(headers[name], vals...)
+	}
+	for name, vals := range opts.Headers {
+		if opts.Version == V2 && !isExtensionHeader(name) {
+			return "", ErrHeaderRequiresV4
+		}
+		headers[name] = append(headers[name], vals...)
+	}
+	if len(opts.ExtraQuery) > 0 && opts.Version != V4 {
+		return "", ErrQueryRequiresV4
+	}
+	for name := range opts.ExtraQuery {
+		if strings.HasPrefix(strings.ToLower(name), "x-goog-") {
+			return "", ErrReservedQueryParameter
+		}
+	}
+	if opts.Version == V4 && opts.TTL > v4MaxTTL {
+		return "", ErrTTLTooLong
+	}
+
+	signer := opts.Signer
+	if signer == nil {
+		signer = AppEngineSigner{}
+	}
+	googleAccessID := opts.GoogleAccessID
+	if googleAccessID == "" {
+		sa, err := signer.ServiceAccount(c)
+		if err != nil {
+			return "", err
+		}
+		googleAccessID = sa
+	}
+
+	host := opts.Host
+	if host == "" {
+		host = defaultHost
+	}
+	p := &urlParams{
+		signer:         signer,
+		googleAccessID: googleAccessID,
+		host:           strings.TrimSuffix(host, "/"),
+		resource:       bo.resource(),
+		httpVerb:       signingVerb(opts.Method, opts.Version),
+		contentMD5:     contentMD5Base64,
+		contentType:    opts.ContentType,
+		headers:        headers,
+		query:          url.Values{},
+	}
+	// The generation is a query parameter, so like the response overrides,
+	// V4 signs it and V2 doesn't.
+	if bo.Generation != 0 {
+		p.query.Set("generation", strconv.FormatInt(bo.Generation, 10))
+	}
+	if opts.ResponseContentDisposition != "" {
+		p.query.Set("response-content-disposition", opts.ResponseContentDisposition)
+	}
+	if opts.ResponseContentType != "" {
+		p.query.Set("response-content-type", opts.ResponseContentType)
+	}
+	for name, vals := range opts.ExtraQuery {
+		p.query[name] = append(p.query[name], vals...)
+	}
+	switch opts.Version {
+	case V4:
+		return generateSignedURLV4(c, p, time.Now(), opts.TTL)
+	default:
+		return generateSignedURLs(c, p, time.Now().Add(opts.TTL))
+	}
+}
+
+// urlParams are the resolved parameters of a signed URL.
+type urlParams struct {
+	signer         Signer
+	googleAccessID string
+	host           string // https://storage.googleapis.com
+	resource       string // /bucket/objectname, escaped
+	httpVerb       string
+	contentMD5     string // base64
+	contentType    string
+	// headers holds additional headers the client must send.
+	headers http.Header
+	// query holds additional query parameters. V4 signs them, V2 doesn't.
+	query url.Values
 }
 
 // Taken from http://stackoverflow.com/a/26579165/196964 and
 // https://cloud.google.com/storage/docs/access-control#Signed-URLs
-func generateSignedURLs(c context.Context, host, resource string, expiry time.Time, httpVerb, contentMD5, contentType string) (string, error) {
-	sa, err := appengine.ServiceAccount(c)
+func generateSignedURLs(c context.Context, p *urlParams, expiry time.Time) (string, error) {
+	expiryStr := strconv.FormatInt(expiry.Unix(), 10)
+	unsigned := stringToSign(p.httpVerb, p.contentMD5, p.contentType, expiryStr, canonicalExtensionHeaders(p.headers), p.resource)
+	sig, err := p.signer.SignBytes(c, []byte(unsigned))
 	if err != nil {
 		return "", err
 	}
-	expiryStr := strconv.FormatInt(expiry.Unix(), 10)
-	// The optional components should be the empty string.
-	// https://cloud.google.com/storage/docs/access-control#Construct-the-String
+	q := url.Values{
+		"GoogleAccessId": {p.googleAccessID},
+		"Expires":        {expiryStr},
+		"Signature":      {signature.EncodeBase64(sig)},
+	}
+	for k, v := range p.query {
+		q[k] = v
+	}
+	return fmt.Sprintf("%s%s?%s", p.host, p.resource, q.Encode()), err
+}
+
+// stringToSign returns the string that is signed for a signed URL.
+// The optional components should be the empty string.
+// https://cloud.google.com/storage/docs/access-control#Construct-the-String
+func stringToSign(httpVerb, contentMD5, contentType, expiry, extensionHeaders, resource string) string {
 	components := []string{
-		httpVerb,    // PUT, GET, DELETE (but not POST)
+		httpVerb,    // PUT, GET, DELETE, or POST to start a resumable upload
 		contentMD5,  // Optional. The MD5 digest value in base64. Client must provide same value if present.
 		contentType, // Optional. Client must provide same value if present.
-		expiryStr,   // Unix timestamp
-		resource,    // /bucket/objectname
+		expiry,      // Unix timestamp
 	}
-	unsigned := strings.Join(components, "\n")
-	_, b, err := appengine.SignBytes(c, []byte(unsigned))
-	if err != nil {
-		return "", err
+	// Optional. Each extension header ends with a newline, so it is
+	// added between the expiry and resource without a separator.
+	return strings.Join(components, "\n") + "\n" + extensionHeaders + resource // /bucket/objectname
+}
+
+// isExtensionHeader reports whether name is an x-goog- extension header.
+func isExtensionHeader(name string) bool {
+	return strings.HasPrefix(strings.ToLower(name), "x-goog-")
+}
+
+// canonicalExtensionHeaders returns the canonical form of the headers in
+// h for the V2 string to sign: lowercase names in sorted order, each
+// followed by its trimmed, comma separated values and a newline.
+// https://cloud.google.com/storage/docs/access-control/signed-urls-v2#about-canonical-extension-headers
+func canonicalExtensionHeaders(h http.Header) string {
+	headers := make(map[string][]string, len(h))
+	for name, vals := range h {
+		name = strings.ToLower(name)
+		for _, v := range vals {
+			headers[name] = append(headers[name], strings.TrimSpace(v))
+		}
 	}
-	sig := base64.StdEncoding.EncodeToString(b)
-	p := url.Values{
-		"GoogleAccessId": {sa},
-		"Expires":        {expiryStr},
-		"Signature":      {sig},
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical string
+	for _, name := range names {
+		canonical += name + ":" + strings.Join(headers[name], ",") + "\n"
 	}
-	return fmt.Sprintf("%s%s?%s", host, resource, p.Encode()), err
+	return canonical
 }
 
 // String returns a gs:// URL that can be used with the gsutil command line tool.
 func (bo *BucketObject) String() string {
+	if bo.Generation != 0 {
+		return "gs://" + bo.Bucket + "/" + bo.Object + "#" + strconv.FormatInt(bo.Generation, 10)
+	}
 	return "gs://" + bo.Bucket + "/" + bo.Object
 }
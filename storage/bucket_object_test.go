@@ -0,0 +1,387 @@
+package storage
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/aetest"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedGetURL(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	bo := &BucketObject{Bucket: "bucket", Object: "object"}
+	signed, err := bo.SignedGetURL(c, 1*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to sign URL. %v", err)
+	}
+	if !strings.HasPrefix(signed, "https://storage.googleapis.com/bucket/object?") {
+		t.Fatalf("Unexpected URL %v", signed)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("Failed to parse signed URL. %v", err)
+	}
+	q := u.Query()
+	for _, param := range []string{"GoogleAccessId", "Expires", "Signature"} {
+		if q.Get(param) == "" {
+			t.Errorf("Expected %v query parameter in %v", param, signed)
+		}
+	}
+}
+
+func TestStringToSignDelete(t *testing.T) {
+	s := stringToSign("DELETE", "", "", "1500000000", "", "/bucket/object")
+	expected := "DELETE\n\n\n1500000000\n/bucket/object"
+	if s != expected {
+		t.Fatalf("Expected %q but got %q", expected, s)
+	}
+}
+
+func TestSignedDeleteURL(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	bo := &BucketObject{Bucket: "bucket", Object: "object"}
+	signed, err := bo.SignedDeleteURL(c, 1*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to sign URL. %v", err)
+	}
+	if !strings.HasPrefix(signed, "https://storage.googleapis.com/bucket/object?") {
+		t.Fatalf("Unexpected URL %v", signed)
+	}
+}
+
+func TestEscapedObjectName(t *testing.T) {
+	bo := &BucketObject{Bucket: "bucket", Object: "dir/sub/file name+ü.txt"}
+	expected := "/bucket/dir/sub/file%20name%2B%C3%BC.txt"
+	if r := bo.resource(); r != expected {
+		t.Fatalf("Expected resource %q but got %q", expected, r)
+	}
+	if u := bo.PublicGetURL(); u != "https://storage.googleapis.com"+expected {
+		t.Fatalf("Expected public URL to use the escaped resource, got %q", u)
+	}
+	u, err := url.Parse(bo.PublicGetURL())
+	if err != nil {
+		t.Fatalf("Failed to parse public URL. %v", err)
+	}
+	if u.Path != "/bucket/dir/sub/file name+ü.txt" {
+		t.Fatalf("Expected the URL path to decode to the object name, got %q", u.Path)
+	}
+}
+
+func TestSignedURLEscapedObjectName(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	bo := &BucketObject{Bucket: "bucket", Object: "dir/sub/file name.txt"}
+	signed, err := bo.SignedGetURL(c, 1*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to sign URL. %v", err)
+	}
+	if !strings.HasPrefix(signed, "https://storage.googleapis.com/bucket/dir/sub/file%20name.txt?") {
+		t.Fatalf("Unexpected URL %v", signed)
+	}
+}
+
+func TestSignedURLGoogleAccessID(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	bo := &BucketObject{Bucket: "bucket", Object: "object"}
+	signed, err := bo.SignedURL(c, &SignedURLOptions{
+		Method:         "GET",
+		TTL:            1 * time.Minute,
+		GoogleAccessID: "signer@example.iam.gserviceaccount.com",
+	})
+	if err != nil {
+		t.Fatalf("Failed to sign URL. %v", err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("Failed to parse signed URL. %v", err)
+	}
+	if id := u.Query().Get("GoogleAccessId"); id != "signer@example.iam.gserviceaccount.com" {
+		t.Fatalf("Expected the overridden GoogleAccessId, got %v", id)
+	}
+}
+
+func TestSignedURLResponseOverrides(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	bo := &BucketObject{Bucket: "bucket", Object: "object"}
+	for _, version := range []SigningVersion{V2, V4} {
+		signed, err := bo.SignedURL(c, &SignedURLOptions{
+			Method:                     "GET",
+			TTL:                        1 * time.Minute,
+			Version:                    version,
+			ResponseContentDisposition: `attachment; filename="report 2017.pdf"`,
+			ResponseContentType:        "application/pdf",
+		})
+		if err != nil {
+			t.Fatalf("Failed to sign URL. %v", err)
+		}
+		u, err := url.Parse(signed)
+		if err != nil {
+			t.Fatalf("Failed to parse signed URL. %v", err)
+		}
+		q := u.Query()
+		if d := q.Get("response-content-disposition"); d != `attachment; filename="report 2017.pdf"` {
+			t.Errorf("Version %v: unexpected response-content-disposition %q", version, d)
+		}
+		if ct := q.Get("response-content-type"); ct != "application/pdf" {
+			t.Errorf("Version %v: unexpected response-content-type %q", version, ct)
+		}
+	}
+}
+
+func TestCanonicalExtensionHeaders(t *testing.T) {
+	h := http.Header{
+		"X-Goog-Meta-Zeta":  {" last "},
+		"x-goog-acl":        {"public-read"},
+		"X-Goog-Meta-Alpha": {"1", "2"},
+	}
+	expected := "x-goog-acl:public-read\nx-goog-meta-alpha:1,2\nx-goog-meta-zeta:last\n"
+	if s := canonicalExtensionHeaders(h); s != expected {
+		t.Fatalf("Expected %q but got %q", expected, s)
+	}
+
+	s := stringToSign("PUT", "", "image/png", "1500000000", canonicalExtensionHeaders(h), "/bucket/object")
+	expected = "PUT\n\nimage/png\n1500000000\n" + expected + "/bucket/object"
+	if s != expected {
+		t.Fatalf("Expected %q but got %q", expected, s)
+	}
+}
+
+func TestSignedURLRejectsNonExtensionHeaders(t *testing.T) {
+	bo := &BucketObject{Bucket: "bucket", Object: "object"}
+	_, err := bo.SignedURL(context.Background(), &SignedURLOptions{
+		Method:           "PUT",
+		TTL:              1 * time.Minute,
+		ExtensionHeaders: http.Header{"Cache-Control": {"no-cache"}},
+	})
+	if err != ErrNotExtensionHeader {
+		t.Fatalf("Expected ErrNotExtensionHeader but got %v", err)
+	}
+}
+
+func TestSignedURLHost(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	p := &urlParams{
+		signer:         AppEngineSigner{},
+		googleAccessID: "signer@example.com",
+		host:           defaultHost,
+		resource:       "/bucket/object",
+		httpVerb:       "GET",
+	}
+	expiry := time.Now().Add(1 * time.Minute)
+	u1, err := generateSignedURLs(c, p, expiry)
+	if err != nil {
+		t.Fatalf("Failed to sign URL. %v", err)
+	}
+	p.host = "http://localhost:4443"
+	u2, err := generateSignedURLs(c, p, expiry)
+	if err != nil {
+		t.Fatalf("Failed to sign URL. %v", err)
+	}
+	if !strings.HasPrefix(u2, "http://localhost:4443/bucket/object?") {
+		t.Fatalf("Unexpected URL %v", u2)
+	}
+	if strings.TrimPrefix(u1, defaultHost) != strings.TrimPrefix(u2, "http://localhost:4443") {
+		t.Fatalf("Expected only the host to change.\n%v\n%v", u1, u2)
+	}
+}
+
+func TestResumableUploadStringToSign(t *testing.T) {
+	opts := resumableUploadOptions("video/mp4", 1*time.Minute)
+	if opts.Method != "POST" {
+		t.Fatalf("Expected POST but got %v", opts.Method)
+	}
+	s := stringToSign(opts.Method, "", opts.ContentType, "1500000000", canonicalExtensionHeaders(opts.ExtensionHeaders), "/bucket/object")
+	expected := "POST\n\nvideo/mp4\n1500000000\nx-goog-resumable:start\n/bucket/object"
+	if s != expected {
+		t.Fatalf("Expected %q but got %q", expected, s)
+	}
+}
+
+func TestAnyContentPutStringToSign(t *testing.T) {
+	opts := anyContentPutOptions(1 * time.Minute)
+	if opts.Method != "PUT" || opts.ContentType != "" || opts.ContentMD5 != "" {
+		t.Fatalf("Expected a PUT without Content-Type or Content-MD5 but got %+v", opts)
+	}
+	// GCS expects the empty Content-MD5 and Content-Type lines to be kept.
+	s := stringToSign(opts.Method, opts.ContentMD5, opts.ContentType, "1500000000", canonicalExtensionHeaders(opts.ExtensionHeaders), "/bucket/object")
+	expected := "PUT\n\n\n1500000000\n/bucket/object"
+	if s != expected {
+		t.Fatalf("Expected %q but got %q", expected, s)
+	}
+}
+
+func TestSignedURLValidation(t *testing.T) {
+	put := func(ttl time.Duration, md5 string) *SignedURLOptions {
+		return &SignedURLOptions{Method: "PUT", TTL: ttl, ContentType: "text/plain", ContentMD5: md5}
+	}
+	tests := []struct {
+		bo   BucketObject
+		opts *SignedURLOptions
+		err  error
+		name string
+	}{
+		{BucketObject{Bucket: "bucket", Object: "object"}, put(0, ""), ErrInvalidTTL, "zero ttl"},
+		{BucketObject{Bucket: "bucket", Object: "object"}, put(-time.Minute, ""), ErrInvalidTTL, "negative ttl"},
+		{BucketObject{Bucket: "bucket", Object: "object"}, put(500*time.Millisecond, ""), ErrInvalidTTL, "sub-second ttl"},
+		{BucketObject{Bucket: "", Object: "object"}, put(time.Minute, ""), ErrEmptyBucket, "empty bucket"},
+		{BucketObject{Bucket: "bucket", Object: ""}, put(time.Minute, ""), ErrEmptyObject, "empty object"},
+		{BucketObject{Bucket: "bucket", Object: "object"}, put(time.Minute, "not hex"), ErrInvalidContentMD5, "malformed md5"},
+		{BucketObject{Bucket: "bucket", Object: "object"}, put(time.Minute, "abcd"), ErrInvalidContentMD5, "short md5"},
+		{BucketObject{Bucket: "bucket", Object: "object"}, nil, ErrNilOptions, "nil options"},
+		{BucketObject{Bucket: "bucket", Object: "object"}, &SignedURLOptions{TTL: time.Minute}, ErrInvalidMethod, "empty method"},
+		{BucketObject{Bucket: "bucket", Object: "object"}, &SignedURLOptions{Method: "get", TTL: time.Minute}, ErrInvalidMethod, "lowercase method"},
+		{BucketObject{Bucket: "bucket", Object: "object"}, &SignedURLOptions{Method: "PATCH", TTL: time.Minute}, ErrInvalidMethod, "unsupported method"},
+	}
+	for _, test := range tests {
+		_, err := test.bo.SignedURL(context.Background(), test.opts)
+		if err != test.err {
+			t.Errorf("%v: expected %v but got %v", test.name, test.err, err)
+		}
+	}
+
+	bo := &BucketObject{Bucket: "bucket", Object: "object"}
+	if _, err := bo.SignedPostPolicy(context.Background(), nil, 0); err != ErrInvalidTTL {
+		t.Errorf("Expected ErrInvalidTTL for a policy but got %v", err)
+	}
+	_, err := bo.SignedURL(context.Background(), &SignedURLOptions{Method: "GET", TTL: 999 * time.Millisecond, Version: V4, Signer: &mockSigner{}})
+	if err != ErrInvalidTTL {
+		t.Errorf("Expected ErrInvalidTTL for a sub-second V4 URL but got %v", err)
+	}
+	if _, err := bo.SignedURL(context.Background(), &SignedURLOptions{Method: "GET", TTL: time.Second, Version: V4, Signer: &mockSigner{}}); err != nil {
+		t.Errorf("Expected a one second V4 URL to be signed. %v", err)
+	}
+}
+
+func TestSigningVerbHead(t *testing.T) {
+	if v := signingVerb("HEAD", V2); v != "GET" {
+		t.Errorf("Expected V2 HEAD to be signed as GET, got %v", v)
+	}
+	if v := signingVerb("HEAD", V4); v != "HEAD" {
+		t.Errorf("Expected V4 HEAD to be signed as HEAD, got %v", v)
+	}
+	if v := signingVerb("PUT", V2); v != "PUT" {
+		t.Errorf("Expected PUT to be signed as PUT, got %v", v)
+	}
+}
+
+func TestSignedHeadURL(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	bo := &BucketObject{Bucket: "bucket", Object: "object"}
+	head, err := bo.SignedHeadURL(c, 1*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to sign URL. %v", err)
+	}
+	get, err := bo.SignedGetURL(c, 1*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to sign URL. %v", err)
+	}
+	hu, _ := url.Parse(head)
+	gu, _ := url.Parse(get)
+	// The URLs are the same unless the expiry crossed a second boundary between calls.
+	if hu.Query().Get("Expires") == gu.Query().Get("Expires") && hu.Query().Get("Signature") != gu.Query().Get("Signature") {
+		t.Fatalf("Expected a V2 HEAD URL to be signed like a GET URL.\n%v\n%v", head, get)
+	}
+}
+
+func TestGeneration(t *testing.T) {
+	bo := &BucketObject{Bucket: "bucket", Object: "object", Generation: 1500000000123456}
+	if u := bo.PublicGetURL(); u != "https://storage.googleapis.com/bucket/object?generation=1500000000123456" {
+		t.Errorf("Unexpected public URL %v", u)
+	}
+	if s := bo.String(); s != "gs://bucket/object#1500000000123456" {
+		t.Errorf("Unexpected gs URL %v", s)
+	}
+}
+
+func TestSignedURLGeneration(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	bo := &BucketObject{Bucket: "bucket", Object: "object", Generation: 1500000000123456}
+	for _, version := range []SigningVersion{V2, V4} {
+		signed, err := bo.SignedURL(c, &SignedURLOptions{Method: "GET", TTL: 1 * time.Minute, Version: version})
+		if err != nil {
+			t.Fatalf("Failed to sign URL. %v", err)
+		}
+		u, err := url.Parse(signed)
+		if err != nil {
+			t.Fatalf("Failed to parse signed URL. %v", err)
+		}
+		if g := u.Query().Get("generation"); g != "1500000000123456" {
+			t.Errorf("Version %v: expected generation parameter, got %q", version, g)
+		}
+	}
+}
+
+func TestSignedURLHeadersRequireV4(t *testing.T) {
+	bo := &BucketObject{Bucket: "bucket", Object: "object"}
+	_, err := bo.SignedURL(context.Background(), &SignedURLOptions{
+		Method:  "PUT",
+		TTL:     1 * time.Minute,
+		Headers: http.Header{"Content-Encoding": {"gzip"}},
+	})
+	if err != ErrHeaderRequiresV4 {
+		t.Fatalf("Expected ErrHeaderRequiresV4 but got %v", err)
+	}
+}
+
+func TestSignedPutURLWithHeaders(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	bo := &BucketObject{Bucket: "bucket", Object: "object"}
+	signed, err := bo.SignedPutURLWithHeaders(c, "text/plain", "", http.Header{"Content-Encoding": {"gzip"}}, 1*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to sign URL. %v", err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("Failed to parse signed URL. %v", err)
+	}
+	if h := u.Query().Get("X-Goog-SignedHeaders"); h != "content-encoding;content-type;host" {
+		t.Fatalf("Expected Content-Encoding to be signed, got %v", h)
+	}
+}
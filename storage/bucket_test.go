@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/aetest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestBatchSignedGetURLs(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	b := &Bucket{Name: "gallery"}
+	objects := []string{"a.png", "b.png", "albums/c.png"}
+	urls, err := b.BatchSignedGetURLs(c, objects, 1*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to sign URLs. %v", err)
+	}
+	if len(urls) != len(objects) {
+		t.Fatalf("Expected %v URLs but got %v", len(objects), len(urls))
+	}
+	for i, signed := range urls {
+		u, err := url.Parse(signed)
+		if err != nil {
+			t.Fatalf("Failed to parse signed URL. %v", err)
+		}
+		if u.Path != "/gallery/"+objects[i] {
+			t.Errorf("Expected URL %v to be for %v", signed, objects[i])
+		}
+		q := u.Query()
+		for _, param := range []string{"GoogleAccessId", "Expires", "Signature"} {
+			if q.Get(param) == "" {
+				t.Errorf("Expected %v query parameter in %v", param, signed)
+			}
+		}
+	}
+}
+
+func TestBatchSignedGetURLsWithSigner(t *testing.T) {
+	signer := &mockSigner{}
+	b := &Bucket{Name: "gallery"}
+	objects := []string{"a.png", "b.png"}
+	urls, err := b.BatchSignedGetURLsWithSigner(context.Background(), signer, objects, 1*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to sign URLs. %v", err)
+	}
+	if signer.accounts != 1 || len(signer.signed) != len(objects) {
+		t.Fatalf("Expected 1 service account lookup and %v signatures, got %v and %v", len(objects), signer.accounts, len(signer.signed))
+	}
+	for _, signed := range urls {
+		u, err := url.Parse(signed)
+		if err != nil {
+			t.Fatalf("Failed to parse signed URL. %v", err)
+		}
+		if q := u.Query(); q.Get("GoogleAccessId") != "signer@example.iam.gserviceaccount.com" {
+			t.Errorf("Expected the signer's service account in %v", signed)
+		}
+	}
+}
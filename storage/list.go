@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/drichardson/appengine/googleapiclient"
+	"golang.org/x/net/context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// The OAuth 2 scope List needs.
+const readOnlyScope = "https://www.googleapis.com/auth/devstorage.read_only"
+
+// The Cloud Storage JSON API endpoint.
+const jsonAPIURL = "https://storage.googleapis.com/storage/v1"
+
+// ObjectAttrs is the metadata of an object returned by List.
+type ObjectAttrs struct {
+	Name        string    `json:"name"`
+	Size        int64     `json:"size,string"`
+	ContentType string    `json:"contentType"`
+	Generation  int64     `json:"generation,string"`
+	MD5Hash     string    `json:"md5Hash"` // base64
+	Updated     time.Time `json:"updated"`
+}
+
+// ObjectList is the result of List.
+type ObjectList struct {
+	// Objects are the objects whose names start with the prefix.
+	Objects []*ObjectAttrs
+	// Prefixes are the names, up to and including the delimiter, that were
+	// rolled up instead of being listed in Objects, like directories.
+	Prefixes []string
+}
+
+// List lists the objects in the bucket whose names start with prefix, using
+// the Cloud Storage JSON API. If delimiter is set, objects whose names
+// contain it after the prefix are rolled up into Prefixes instead, so that
+// the bucket can be listed like a directory tree; use "/" for that. c must
+// be a context created with appengine.NewContext, whose service account must
+// be able to list the bucket. All pages of results are fetched.
+func (b *Bucket) List(c context.Context, prefix, delimiter string) (*ObjectList, error) {
+	return b.list(c, googleapiclient.NewClient(c, readOnlyScope), prefix, delimiter)
+}
+
+func (b *Bucket) list(c context.Context, client *http.Client, prefix, delimiter string) (*ObjectList, error) {
+	if b.Name == "" {
+		return nil, ErrEmptyBucket
+	}
+	list := &ObjectList{}
+	pageToken := ""
+	for {
+		q := url.Values{}
+		if prefix != "" {
+			q.Set("prefix", prefix)
+		}
+		if delimiter != "" {
+			q.Set("delimiter", delimiter)
+		}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		u := jsonAPIURL + "/b/" + url.PathEscape(b.Name) + "/o"
+		if len(q) > 0 {
+			u += "?" + q.Encode()
+		}
+		req, err := http.NewRequest("GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req.WithContext(c))
+		if err != nil {
+			return nil, err
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("storage: listing %v: %v: %s", b.Name, resp.Status, body)
+		}
+
+		var page struct {
+			Items         []*ObjectAttrs `json:"items"`
+			Prefixes      []string       `json:"prefixes"`
+			NextPageToken string         `json:"nextPageToken"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, err
+		}
+		list.Objects = append(list.Objects, page.Items...)
+		list.Prefixes = append(list.Prefixes, page.Prefixes...)
+		if page.NextPageToken == "" {
+			return list, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
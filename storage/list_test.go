@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"encoding/json"
+	"github.com/drichardson/appengine/googleapiclient"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// listTransport fakes the Cloud Storage JSON API's objects.list method for a
+// bucket with a few objects, returning one object or prefix per page.
+type listTransport struct {
+	t        *testing.T
+	requests []*http.Request
+}
+
+func (tr *listTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tr.requests = append(tr.requests, req)
+	if req.URL.Path != "/storage/v1/b/gallery/o" {
+		tr.t.Errorf("Unexpected path %v", req.URL.Path)
+	}
+	if auth := req.Header.Get("Authorization"); auth != "Bearer token" {
+		tr.t.Errorf("Expected an authorized request, got %v", auth)
+	}
+
+	q := req.URL.Query()
+	names := []string{"albums/2015/a.png", "albums/2016/b.png", "albums/c.png", "d.png"}
+	var results []map[string]interface{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		if !strings.HasPrefix(name, q.Get("prefix")) {
+			continue
+		}
+		rest := name[len(q.Get("prefix")):]
+		if d := q.Get("delimiter"); d != "" && strings.Contains(rest, d) {
+			p := q.Get("prefix") + rest[:strings.Index(rest, d)+len(d)]
+			if !seen[p] {
+				seen[p] = true
+				results = append(results, map[string]interface{}{"prefixes": []string{p}})
+			}
+			continue
+		}
+		results = append(results, map[string]interface{}{
+			"items": []map[string]string{{"name": name, "size": "42", "generation": "7"}},
+		})
+	}
+
+	page := map[string]interface{}{}
+	i := 0
+	if token := q.Get("pageToken"); token != "" {
+		i, _ = strconv.Atoi(token)
+	}
+	if i < len(results) {
+		page = results[i]
+		if i+1 < len(results) {
+			page["nextPageToken"] = strconv.Itoa(i + 1)
+		}
+	}
+	b, _ := json.Marshal(page)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(string(b))),
+		Request:    req,
+	}, nil
+}
+
+func TestBucketList(t *testing.T) {
+	src := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	b := &Bucket{Name: "gallery"}
+
+	tests := []struct {
+		prefix    string
+		delimiter string
+		objects   []string
+		prefixes  []string
+	}{
+		{"", "", []string{"albums/2015/a.png", "albums/2016/b.png", "albums/c.png", "d.png"}, nil},
+		{"albums/", "", []string{"albums/2015/a.png", "albums/2016/b.png", "albums/c.png"}, nil},
+		{"", "/", []string{"d.png"}, []string{"albums/"}},
+		{"albums/", "/", []string{"albums/c.png"}, []string{"albums/2015/", "albums/2016/"}},
+		{"photos/", "/", nil, nil},
+	}
+	for _, test := range tests {
+		tr := &listTransport{t: t}
+		client := googleapiclient.NewClientFromTokenSource(src, tr)
+		list, err := b.list(context.Background(), client, test.prefix, test.delimiter)
+		if err != nil {
+			t.Fatalf("List(%q, %q) failed. %v", test.prefix, test.delimiter, err)
+		}
+		var objects []string
+		for _, o := range list.Objects {
+			objects = append(objects, o.Name)
+			if o.Size != 42 || o.Generation != 7 {
+				t.Errorf("Expected size and generation to be decoded, got %+v", o)
+			}
+		}
+		if !reflect.DeepEqual(objects, test.objects) {
+			t.Errorf("List(%q, %q): expected objects %v, got %v", test.prefix, test.delimiter, test.objects, objects)
+		}
+		if !reflect.DeepEqual(list.Prefixes, test.prefixes) {
+			t.Errorf("List(%q, %q): expected prefixes %v, got %v", test.prefix, test.delimiter, test.prefixes, list.Prefixes)
+		}
+		if len(tr.requests) == 0 || tr.requests[0].URL.Query().Get("prefix") != test.prefix {
+			t.Errorf("List(%q, %q): expected the prefix to be sent", test.prefix, test.delimiter)
+		}
+	}
+}
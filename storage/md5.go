@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"golang.org/x/net/context"
+	"io"
+	"time"
+)
+
+// MD5Hex returns the hex encoded MD5 digest of data, in the form SignedPutURL
+// and SignedURLOptions.ContentMD5 expect.
+func MD5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ContentMD5Header converts a hex encoded MD5 digest, as returned by MD5Hex,
+// to the base64 encoding a client must send in the Content-MD5 header of an
+// upload to a URL signed with it. It returns ErrInvalidContentMD5 if
+// contentMD5 isn't a hex encoded 16 byte digest.
+func ContentMD5Header(contentMD5 string) (string, error) {
+	b, err := hex.DecodeString(contentMD5)
+	if err != nil || len(b) != md5.Size {
+		return "", ErrInvalidContentMD5
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// MD5HexFromBase64 converts a base64 encoded MD5 digest, such as
+// ObjectAttrs.MD5Hash, to the hex encoding MD5Hex returns. It returns
+// ErrInvalidContentMD5 if md5Base64 isn't a base64 encoded 16 byte digest.
+func MD5HexFromBase64(md5Base64 string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(md5Base64)
+	if err != nil || len(b) != md5.Size {
+		return "", ErrInvalidContentMD5
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// SignedPutURLForContent is like SignedPutURL, but computes the MD5 digest of
+// data itself, so the URL can only be used to upload exactly data. The
+// client must send Content-MD5 with the value of
+// ContentMD5Header(MD5Hex(data)).
+func (bo *BucketObject) SignedPutURLForContent(c context.Context, data []byte, contentType string, ttl time.Duration) (string, error) {
+	return bo.SignedPutURL(c, contentType, MD5Hex(data), ttl)
+}
+
+// VerifyDownload reads r to the end, such as the body of a response from a
+// signed GET URL, and reports whether its MD5 digest is expectedMD5Hex, the
+// hex encoded digest of the object, so truncated or corrupted downloads can
+// be detected. It returns ErrInvalidContentMD5 if expectedMD5Hex isn't a hex
+// encoded 16 byte digest, and any error reading r.
+func VerifyDownload(r io.Reader, expectedMD5Hex string) (bool, error) {
+	expected, err := hex.DecodeString(expectedMD5Hex)
+	if err != nil || len(expected) != md5.Size {
+		return false, ErrInvalidContentMD5
+	}
+	h := md5.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return false, err
+	}
+	return bytes.Equal(h.Sum(nil), expected), nil
+}
+
+// VerifyDownload is like the VerifyDownload function, but checks r against
+// the MD5Hash of the object as returned by List. It returns
+// ErrInvalidContentMD5 if the object has no MD5Hash, as is the case for
+// composite objects.
+func (a *ObjectAttrs) VerifyDownload(r io.Reader) (bool, error) {
+	expected, err := MD5HexFromBase64(a.MD5Hash)
+	if err != nil {
+		return false, err
+	}
+	return VerifyDownload(r, expected)
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestMD5Hex(t *testing.T) {
+	// From RFC 1321.
+	if got := MD5Hex([]byte("abc")); got != "900150983cd24fb0d6963f7d28e17f72" {
+		t.Errorf("Unexpected MD5 digest %v", got)
+	}
+	if got := MD5Hex(nil); got != "d41d8cd98f00b204e9800998ecf8427e" {
+		t.Errorf("Unexpected MD5 digest of empty content %v", got)
+	}
+}
+
+func TestContentMD5Header(t *testing.T) {
+	got, err := ContentMD5Header(MD5Hex([]byte("abc")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "kAFQmDzST7DWlj99KOF/cg==" {
+		t.Errorf("Unexpected Content-MD5 %v", got)
+	}
+
+	invalid := []string{
+		"kAFQmDzST7DWlj99KOF/cg==",           // already base64
+		"900150983cd24fb0d6963f7d28e17f",     // too short
+		"900150983cd24fb0d6963f7d28e17f72ab", // too long
+		"",
+	}
+	for _, contentMD5 := range invalid {
+		if _, err := ContentMD5Header(contentMD5); err != ErrInvalidContentMD5 {
+			t.Errorf("Expected ErrInvalidContentMD5 for %q, got %v", contentMD5, err)
+		}
+	}
+}
+
+// errReader returns err after the content of r.
+type errReader struct {
+	r   io.Reader
+	err error
+}
+
+func (er *errReader) Read(p []byte) (int, error) {
+	n, err := er.r.Read(p)
+	if err == io.EOF {
+		err = er.err
+	}
+	return n, err
+}
+
+func TestVerifyDownload(t *testing.T) {
+	content := "hello, world!"
+	tests := []struct {
+		body     string
+		expected string
+		ok       bool
+	}{
+		{content, MD5Hex([]byte(content)), true},
+		{content, strings.ToUpper(MD5Hex([]byte(content))), true},
+		{content[:5], MD5Hex([]byte(content)), false},
+		{"hello, World!", MD5Hex([]byte(content)), false},
+		{"", MD5Hex(nil), true},
+	}
+	for _, test := range tests {
+		ok, err := VerifyDownload(strings.NewReader(test.body), test.expected)
+		if err != nil {
+			t.Errorf("Unexpected error verifying %q. %v", test.body, err)
+		}
+		if ok != test.ok {
+			t.Errorf("Expected %v verifying %q against %v, got %v", test.ok, test.body, test.expected, ok)
+		}
+	}
+
+	if _, err := VerifyDownload(strings.NewReader(content), "kAFQmDzST7DWlj99KOF/cg=="); err != ErrInvalidContentMD5 {
+		t.Errorf("Expected ErrInvalidContentMD5, got %v", err)
+	}
+	readErr := errors.New("connection reset")
+	r := &errReader{r: strings.NewReader(content), err: readErr}
+	if ok, err := VerifyDownload(r, MD5Hex([]byte(content))); ok || err != readErr {
+		t.Errorf("Expected the read error, got %v, %v", ok, err)
+	}
+}
+
+func TestMD5HexFromBase64(t *testing.T) {
+	got, err := MD5HexFromBase64("kAFQmDzST7DWlj99KOF/cg==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != MD5Hex([]byte("abc")) {
+		t.Errorf("Unexpected MD5 digest %v", got)
+	}
+
+	invalid := []string{
+		"900150983cd24fb0d6963f7d28e17f72", // already hex
+		"kAFQmDzST7DWlj99KOF/",             // too short
+		"",
+	}
+	for _, md5Base64 := range invalid {
+		if _, err := MD5HexFromBase64(md5Base64); err != ErrInvalidContentMD5 {
+			t.Errorf("Expected ErrInvalidContentMD5 for %q, got %v", md5Base64, err)
+		}
+	}
+}
+
+func TestObjectAttrsVerifyDownload(t *testing.T) {
+	// MD5Hash as returned by List for an object containing "abc".
+	attrs := &ObjectAttrs{Name: "abc.txt", MD5Hash: "kAFQmDzST7DWlj99KOF/cg=="}
+	if ok, err := attrs.VerifyDownload(strings.NewReader("abc")); !ok || err != nil {
+		t.Errorf("Expected download to verify, got %v, %v", ok, err)
+	}
+	if ok, err := attrs.VerifyDownload(strings.NewReader("ab")); ok || err != nil {
+		t.Errorf("Expected truncated download not to verify, got %v, %v", ok, err)
+	}
+	composite := &ObjectAttrs{Name: "composite"}
+	if _, err := composite.VerifyDownload(strings.NewReader("abc")); err != ErrInvalidContentMD5 {
+		t.Errorf("Expected ErrInvalidContentMD5 without an MD5Hash, got %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"errors"
+	"github.com/drichardson/appengine/signature"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error codes returned by ParseSignedURL and VerifySignedURL.
+var (
+	ErrNotSignedURL         = errors.New("ErrNotSignedURL")
+	ErrV4URLNotSupported    = errors.New("ErrV4URLNotSupported")
+	ErrSignedURLExpired     = errors.New("ErrSignedURLExpired")
+	ErrSignedURLBadResource = errors.New("ErrSignedURLBadResource")
+	ErrSignedURLWrongSigner = errors.New("ErrSignedURLWrongSigner")
+)
+
+// ParsedSignedURL holds the parameters of a V2 signed URL.
+type ParsedSignedURL struct {
+	BucketObject
+	// Host is the scheme and host the URL is for, e.g., https://storage.googleapis.com.
+	Host           string
+	GoogleAccessID string
+	Expires        time.Time
+	// Signature is the base64 encoded signature.
+	Signature string
+
+	resource string
+}
+
+// ParseSignedURL extracts the parameters of a V2 signed URL, such as one made
+// by SignedGetURL. It checks the URL's structure, but not its signature or
+// expiry; see VerifySignedURL. It returns ErrV4URLNotSupported for V4 URLs.
+func ParseSignedURL(rawurl string) (*ParsedSignedURL, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	if q.Get("X-Goog-Algorithm") != "" {
+		return nil, ErrV4URLNotSupported
+	}
+	accessID, expiresStr, sig := q.Get("GoogleAccessId"), q.Get("Expires"), q.Get("Signature")
+	if u.Scheme == "" || u.Host == "" || accessID == "" || expiresStr == "" || sig == "" {
+		return nil, ErrNotSignedURL
+	}
+	expires, err := strconv.ParseInt(expiresStr, 10, 64)
+	if err != nil {
+		return nil, ErrNotSignedURL
+	}
+
+	// The resource is /bucket/object, even for custom hosts.
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, ErrSignedURLBadResource
+	}
+	p := &ParsedSignedURL{
+		BucketObject:   BucketObject{Bucket: parts[0], Object: parts[1]},
+		Host:           u.Scheme + "://" + u.Host,
+		GoogleAccessID: accessID,
+		Expires:        time.Unix(expires, 0),
+		Signature:      sig,
+		resource:       u.EscapedPath(),
+	}
+	if g := q.Get("generation"); g != "" {
+		p.Generation, err = strconv.ParseInt(g, 10, 64)
+		if err != nil {
+			return nil, ErrNotSignedURL
+		}
+	}
+	return p, nil
+}
+
+// VerifySignedURL parses rawurl with ParseSignedURL and checks that it hasn't
+// expired and that it was signed by this app's service account for method,
+// e.g., GET. Only URLs signed without a Content-Type, Content-MD5, or
+// extension headers, such as those made by SignedGetURL, SignedHeadURL, and
+// SignedDeleteURL, can be verified, since those values aren't in the URL.
+// ErrSignedURLWrongSigner is returned if the URL's GoogleAccessId isn't this
+// app's service account, since GCS would reject it even if the signature is
+// this app's. c must be a context created with appengine.NewContext.
+func VerifySignedURL(c context.Context, method, rawurl string) (*ParsedSignedURL, error) {
+	p, err := ParseSignedURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(p.Expires) {
+		return nil, ErrSignedURLExpired
+	}
+	account, err := appengine.ServiceAccount(c)
+	if err != nil {
+		return nil, err
+	}
+	if p.GoogleAccessID != account {
+		return nil, ErrSignedURLWrongSigner
+	}
+	sig, err := signature.DecodeBase64(p.Signature)
+	if err != nil {
+		return nil, ErrNotSignedURL
+	}
+	unsigned := stringToSign(signingVerb(method, V2), "", "", strconv.FormatInt(p.Expires.Unix(), 10), "", p.resource)
+	if err := signature.VerifyBytes(c, []byte(unsigned), sig); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
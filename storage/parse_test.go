@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"google.golang.org/appengine/aetest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseSignedURL(t *testing.T) {
+	p, err := ParseSignedURL("https://storage.googleapis.com/gallery/albums/a%20b.png?Expires=1500000000&GoogleAccessId=app%40appspot.gserviceaccount.com&Signature=c2ln&generation=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Bucket != "gallery" || p.Object != "albums/a b.png" || p.Generation != 7 {
+		t.Errorf("Unexpected object %v", p.BucketObject.String())
+	}
+	if p.Host != "https://storage.googleapis.com" || p.GoogleAccessID != "app@appspot.gserviceaccount.com" || p.Signature != "c2ln" {
+		t.Errorf("Unexpected parameters %+v", p)
+	}
+	if p.Expires.Unix() != 1500000000 {
+		t.Errorf("Unexpected expiry %v", p.Expires)
+	}
+	if p.resource != "/gallery/albums/a%20b.png" {
+		t.Errorf("Unexpected resource %v", p.resource)
+	}
+
+	tests := []struct {
+		url string
+		err error
+	}{
+		{"https://storage.googleapis.com/gallery/a.png", ErrNotSignedURL},
+		{"https://storage.googleapis.com/gallery/a.png?Expires=soon&GoogleAccessId=a&Signature=c2ln", ErrNotSignedURL},
+		{"https://storage.googleapis.com/gallery?Expires=1500000000&GoogleAccessId=a&Signature=c2ln", ErrSignedURLBadResource},
+		{"https://storage.googleapis.com/gallery/a.png?X-Goog-Algorithm=GOOG4-RSA-SHA256", ErrV4URLNotSupported},
+	}
+	for _, test := range tests {
+		if _, err := ParseSignedURL(test.url); err != test.err {
+			t.Errorf("Expected %v for %v, got %v", test.err, test.url, err)
+		}
+	}
+}
+
+func TestVerifySignedURL(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	bo := &BucketObject{Bucket: "gallery", Object: "albums/a b.png"}
+	signed, err := bo.SignedGetURL(c, 1*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to sign URL. %v", err)
+	}
+	p, err := VerifySignedURL(c, "GET", signed)
+	if err != nil {
+		t.Fatalf("Expected signed URL to verify. %v", err)
+	}
+	if p.BucketObject != *bo {
+		t.Errorf("Expected %v but got %v", bo, &p.BucketObject)
+	}
+	if _, err := VerifySignedURL(c, "DELETE", signed); err == nil {
+		t.Error("Expected verification for a different method to fail")
+	}
+
+	// The app's signature with another account's GoogleAccessId is
+	// rejected by GCS, so it must not verify.
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("GoogleAccessId", "someone-else@example.iam.gserviceaccount.com")
+	u.RawQuery = q.Encode()
+	if _, err := VerifySignedURL(c, "GET", u.String()); err != ErrSignedURLWrongSigner {
+		t.Errorf("Expected ErrSignedURLWrongSigner but got %v", err)
+	}
+}
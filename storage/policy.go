@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/drichardson/appengine/signature"
+	"golang.org/x/net/context"
+	"time"
+)
+
+// PolicyCondition is a condition in a POST policy document. Create them with
+// ContentLengthRange, StartsWith, and Equals.
+// https://cloud.google.com/storage/docs/xml-api/post-object#policydocument
+type PolicyCondition []interface{}
+
+// ContentLengthRange requires the size of the uploaded content, in bytes,
+// to be between min and max, inclusive.
+func ContentLengthRange(min, max int64) PolicyCondition {
+	return PolicyCondition{"content-length-range", min, max}
+}
+
+// StartsWith requires the form field to start with prefix.
+func StartsWith(field, prefix string) PolicyCondition {
+	return PolicyCondition{"starts-with", "$" + field, prefix}
+}
+
+// Equals requires the form field to equal value, e.g., Equals("Content-Type", "image/png").
+func Equals(field, value string) PolicyCondition {
+	return PolicyCondition{"eq", "$" + field, value}
+}
+
+// PostPolicy holds what a browser needs to upload an object with an HTML form.
+// The form is POSTed to URL and must include the other fields, with the names
+// returned by FormFields, before the file field.
+type PostPolicy struct {
+	URL            string `json:"url"`
+	Key            string `json:"key"`
+	GoogleAccessID string `json:"googleAccessId"`
+	Policy         string `json:"policy"`
+	Signature      string `json:"signature"`
+}
+
+// FormFields returns the form field names and values of the policy.
+func (pp *PostPolicy) FormFields() map[string]string {
+	return map[string]string{
+		"key":            pp.Key,
+		"GoogleAccessId": pp.GoogleAccessID,
+		"policy":         pp.Policy,
+		"signature":      pp.Signature,
+	}
+}
+
+// SignedPostPolicy makes a signed policy document that lets anyone with it
+// upload the object with an HTML form POST, subject to conditions. The bucket
+// and key conditions are added automatically. Other form fields the client
+// sends, such as Content-Type, must be allowed by a condition.
+// ttl (time to live) is the duration the policy is valid for.
+func (bo *BucketObject) SignedPostPolicy(c context.Context, conditions []PolicyCondition, ttl time.Duration) (*PostPolicy, error) {
+	return bo.SignedPostPolicyWithSigner(c, AppEngineSigner{}, conditions, ttl)
+}
+
+// SignedPostPolicyWithSigner is like SignedPostPolicy, but the policy is
+// signed by signer, such as an IAMSigner, instead of the app's service account.
+func (bo *BucketObject) SignedPostPolicyWithSigner(c context.Context, signer Signer, conditions []PolicyCondition, ttl time.Duration) (*PostPolicy, error) {
+	if err := bo.validate(ttl); err != nil {
+		return nil, err
+	}
+	sa, err := signer.ServiceAccount(c)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := bo.policyDocument(conditions, time.Now().Add(ttl))
+	if err != nil {
+		return nil, err
+	}
+	policy := base64.StdEncoding.EncodeToString(doc)
+	sig, err := signer.SignBytes(c, []byte(policy))
+	if err != nil {
+		return nil, err
+	}
+	return &PostPolicy{
+		URL:            defaultHost + "/" + escapePath(bo.Bucket),
+		Key:            bo.Object,
+		GoogleAccessID: sa,
+		Policy:         policy,
+		Signature:      signature.EncodeBase64(sig),
+	}, nil
+}
+
+// Error code that indicates an upload size range is negative or empty.
+var ErrInvalidContentLengthRange = errors.New("ErrInvalidContentLengthRange")
+
+// SignedUploadPolicy makes a signed policy document that lets anyone with it
+// upload the object with an HTML form POST, as long as the content is between
+// minBytes and maxBytes long, inclusive. GCS rejects uploads outside that range.
+// If contentType is not empty, the form must send the same Content-Type.
+// ttl (time to live) is the duration the policy is valid for.
+//
+// A signed PUT URL can't limit the content length by itself, in either V2 or V4.
+// The closest equivalent is signing an x-goog-content-length-range: min,max
+// header with SignedURLOptions.ExtensionHeaders, which the client must then send
+// and GCS enforces. Use a POST policy when the client can't be trusted to
+// send that header correctly.
+func (bo *BucketObject) SignedUploadPolicy(c context.Context, contentType string, minBytes, maxBytes int64, ttl time.Duration) (*PostPolicy, error) {
+	conditions, err := uploadConditions(contentType, minBytes, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	return bo.SignedPostPolicy(c, conditions, ttl)
+}
+
+func uploadConditions(contentType string, minBytes, maxBytes int64) ([]PolicyCondition, error) {
+	if minBytes < 0 || maxBytes < minBytes {
+		return nil, ErrInvalidContentLengthRange
+	}
+	conditions := []PolicyCondition{ContentLengthRange(minBytes, maxBytes)}
+	if contentType != "" {
+		conditions = append(conditions, Equals("Content-Type", contentType))
+	}
+	return conditions, nil
+}
+
+// policyDocument returns the JSON policy document for the object.
+func (bo *BucketObject) policyDocument(conditions []PolicyCondition, expiry time.Time) ([]byte, error) {
+	all := []interface{}{
+		map[string]string{"bucket": bo.Bucket},
+		map[string]string{"key": bo.Object},
+	}
+	for _, condition := range conditions {
+		all = append(all, condition)
+	}
+	return json.Marshal(struct {
+		Expiration string        `json:"expiration"`
+		Conditions []interface{} `json:"conditions"`
+	}{
+		Expiration: expiry.UTC().Format(time.RFC3339),
+		Conditions: all,
+	})
+}
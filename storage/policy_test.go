@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"encoding/base64"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/aetest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPolicyDocument(t *testing.T) {
+	bo := &BucketObject{Bucket: "bucket", Object: "uploads/photo.png"}
+	doc, err := bo.policyDocument([]PolicyCondition{
+		ContentLengthRange(0, 1048576),
+		Equals("Content-Type", "image/png"),
+		StartsWith("x-goog-meta-owner", "user-"),
+	}, time.Date(2017, 7, 14, 1, 2, 3, 0, time.FixedZone("test", 3600)))
+	if err != nil {
+		t.Fatalf("Failed to make policy document. %v", err)
+	}
+	expected := `{"expiration":"2017-07-14T00:02:03Z","conditions":[` +
+		`{"bucket":"bucket"},{"key":"uploads/photo.png"},` +
+		`["content-length-range",0,1048576],` +
+		`["eq","$Content-Type","image/png"],` +
+		`["starts-with","$x-goog-meta-owner","user-"]]}`
+	if string(doc) != expected {
+		t.Fatalf("Expected policy\n%s\nbut got\n%s", expected, doc)
+	}
+}
+
+func TestSignedPostPolicy(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	bo := &BucketObject{Bucket: "bucket", Object: "uploads/photo.png"}
+	pp, err := bo.SignedPostPolicy(c, []PolicyCondition{ContentLengthRange(0, 1048576)}, 1*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to sign policy. %v", err)
+	}
+	if pp.URL != "https://storage.googleapis.com/bucket" || pp.Key != "uploads/photo.png" {
+		t.Fatalf("Unexpected policy URL or key %+v", pp)
+	}
+	if _, err := base64.StdEncoding.DecodeString(pp.Policy); err != nil {
+		t.Fatalf("Expected base64 policy. %v", err)
+	}
+	if _, err := base64.StdEncoding.DecodeString(pp.Signature); err != nil || pp.Signature == "" {
+		t.Fatalf("Expected base64 signature. %v", err)
+	}
+	fields := pp.FormFields()
+	for _, name := range []string{"key", "GoogleAccessId", "policy", "signature"} {
+		if fields[name] == "" {
+			t.Errorf("Expected form field %v", name)
+		}
+	}
+}
+
+func TestSignedPostPolicyWithSigner(t *testing.T) {
+	signer := &mockSigner{}
+	bo := &BucketObject{Bucket: "bucket", Object: "uploads/photo.png"}
+	pp, err := bo.SignedPostPolicyWithSigner(context.Background(), signer, []PolicyCondition{ContentLengthRange(0, 1048576)}, 1*time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to sign policy. %v", err)
+	}
+	if pp.GoogleAccessID != "signer@example.iam.gserviceaccount.com" {
+		t.Errorf("Expected the signer's service account, got %v", pp.GoogleAccessID)
+	}
+	if pp.Signature != base64.StdEncoding.EncodeToString([]byte("signature")) {
+		t.Errorf("Expected the signer's signature, got %v", pp.Signature)
+	}
+	if len(signer.signed) != 1 || signer.signed[0] != pp.Policy {
+		t.Errorf("Expected the signer to sign the base64 policy %q, got %q", pp.Policy, signer.signed)
+	}
+}
+
+func TestUploadConditions(t *testing.T) {
+	conditions, err := uploadConditions("image/png", 1, 1024)
+	if err != nil {
+		t.Fatalf("Failed to make conditions. %v", err)
+	}
+	expected := []PolicyCondition{
+		{"content-length-range", int64(1), int64(1024)},
+		{"eq", "$Content-Type", "image/png"},
+	}
+	if !reflect.DeepEqual(conditions, expected) {
+		t.Fatalf("Expected %v but got %v", expected, conditions)
+	}
+
+	conditions, err = uploadConditions("", 0, 0)
+	if err != nil || len(conditions) != 1 {
+		t.Fatalf("Expected only a content-length-range condition, got %v %v", conditions, err)
+	}
+
+	for _, r := range [][2]int64{{-1, 10}, {10, 9}} {
+		if _, err := uploadConditions("", r[0], r[1]); err != ErrInvalidContentLengthRange {
+			t.Errorf("Range %v: expected ErrInvalidContentLengthRange but got %v", r, err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/drichardson/appengine/googleapiclient"
+	"github.com/drichardson/appengine/signature"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// Signer signs URLs as a service account. The default, AppEngineSigner, signs
+// as the app's default service account.
+type Signer interface {
+	// ServiceAccount returns the email address of the account whose key
+	// SignBytes signs with.
+	ServiceAccount(c context.Context) (string, error)
+
+	// SignBytes returns the RSA PKCS #1 v1.5 signature of the SHA-256
+	// digest of bytes.
+	SignBytes(c context.Context, bytes []byte) ([]byte, error)
+}
+
+// AppEngineSigner signs with signature.SignBytes as the app's default service
+// account. c must be a context created with appengine.NewContext.
+type AppEngineSigner struct{}
+
+// ServiceAccount implements Signer.
+func (AppEngineSigner) ServiceAccount(c context.Context) (string, error) {
+	return appengine.ServiceAccount(c)
+}
+
+// SignBytes implements Signer.
+func (AppEngineSigner) SignBytes(c context.Context, bytes []byte) ([]byte, error) {
+	_, sig, err := signature.SignBytes(c, bytes)
+	return sig, err
+}
+
+// IAMSigner signs as any service account with the signBlob method of the IAM
+// Service Account Credentials API, so URLs can be signed as an account other
+// than the app's default service account, or where appengine.SignBytes isn't
+// available. The API must be enabled for the project, and the calling account
+// needs the iam.serviceAccounts.signBlob permission on Email, which the
+// Service Account Token Creator role (roles/iam.serviceAccountTokenCreator)
+// grants. Email must also have access to the objects the URLs are for.
+type IAMSigner struct {
+	// Email is the service account to sign as.
+	Email string
+
+	// Client calls the IAM API. If nil, googleapiclient.NewClient is used,
+	// authorized as the app's default service account.
+	Client *http.Client
+}
+
+// ServiceAccount implements Signer.
+func (s *IAMSigner) ServiceAccount(c context.Context) (string, error) {
+	return s.Email, nil
+}
+
+// SignBytes implements Signer.
+func (s *IAMSigner) SignBytes(c context.Context, data []byte) ([]byte, error) {
+	client := s.Client
+	if client == nil {
+		client = googleapiclient.NewClient(c, "https://www.googleapis.com/auth/cloud-platform")
+	}
+	body, err := json.Marshal(struct {
+		Payload string `json:"payload"`
+	}{base64.StdEncoding.EncodeToString(data)})
+	if err != nil {
+		return nil, err
+	}
+	u := "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/" +
+		url.PathEscape(s.Email) + ":signBlob"
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req.WithContext(c))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("storage: signing as %v: %v: %s", s.Email, resp.Status, b)
+	}
+	var signed struct {
+		SignedBlob string `json:"signedBlob"`
+	}
+	if err := json.Unmarshal(b, &signed); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(signed.SignedBlob)
+}
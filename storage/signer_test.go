@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/drichardson/appengine/googleapiclient"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mockSigner records what it signs and returns a fixed signature.
+type mockSigner struct {
+	signed   []string
+	accounts int
+}
+
+func (s *mockSigner) ServiceAccount(c context.Context) (string, error) {
+	s.accounts++
+	return "signer@example.iam.gserviceaccount.com", nil
+}
+
+func (s *mockSigner) SignBytes(c context.Context, bytes []byte) ([]byte, error) {
+	s.signed = append(s.signed, string(bytes))
+	return []byte("signature"), nil
+}
+
+func TestSignedURLSigner(t *testing.T) {
+	signer := &mockSigner{}
+	bo := &BucketObject{Bucket: "bucket", Object: "object"}
+	signed, err := bo.SignedURL(context.Background(), &SignedURLOptions{
+		Method: "GET",
+		TTL:    1 * time.Minute,
+		Signer: signer,
+	})
+	if err != nil {
+		t.Fatalf("Failed to sign URL. %v", err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("GoogleAccessId") != "signer@example.iam.gserviceaccount.com" {
+		t.Errorf("Expected the signer's service account, got %v", q.Get("GoogleAccessId"))
+	}
+	if q.Get("Signature") != base64.StdEncoding.EncodeToString([]byte("signature")) {
+		t.Errorf("Expected the signer's signature, got %v", q.Get("Signature"))
+	}
+	expected := stringToSign("GET", "", "", q.Get("Expires"), "", "/bucket/object")
+	if len(signer.signed) != 1 || signer.signed[0] != expected {
+		t.Errorf("Expected the signer to sign %q, got %q", expected, signer.signed)
+	}
+
+	signed, err = bo.SignedURL(context.Background(), &SignedURLOptions{
+		Method:  "GET",
+		TTL:     1 * time.Minute,
+		Signer:  signer,
+		Version: V4,
+	})
+	if err != nil {
+		t.Fatalf("Failed to sign V4 URL. %v", err)
+	}
+	if !strings.HasSuffix(signed, "&X-Goog-Signature="+hex.EncodeToString([]byte("signature"))) {
+		t.Errorf("Expected the signer's signature in %v", signed)
+	}
+}
+
+func TestSignedUploadDownloadURLs(t *testing.T) {
+	signer := &mockSigner{}
+	bo := &BucketObject{Bucket: "bucket", Object: "object"}
+	md5 := MD5Hex([]byte("hello"))
+	put, get, err := bo.signedUploadDownloadURLs(context.Background(), signer, "text/plain", md5, 10*time.Minute, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to sign URLs. %v", err)
+	}
+	if signer.accounts != 1 {
+		t.Errorf("Expected the service account to be looked up once, got %v", signer.accounts)
+	}
+
+	expires := make(map[string]int64)
+	for name, signed := range map[string]string{"put": put, "get": get} {
+		u, err := url.Parse(signed)
+		if err != nil {
+			t.Fatalf("Failed to parse %v URL %v. %v", name, signed, err)
+		}
+		if u.Scheme != "https" || u.Host != "storage.googleapis.com" || u.Path != "/bucket/object" {
+			t.Errorf("Unexpected %v URL %v", name, signed)
+		}
+		q := u.Query()
+		if q.Get("GoogleAccessId") != "signer@example.iam.gserviceaccount.com" || q.Get("Signature") == "" {
+			t.Errorf("Expected %v URL to be signed by the signer, got %v", name, signed)
+		}
+		expires[name], err = strconv.ParseInt(q.Get("Expires"), 10, 64)
+		if err != nil {
+			t.Errorf("Bad Expires in %v URL %v. %v", name, signed, err)
+		}
+	}
+	if expires["get"] <= expires["put"] {
+		t.Errorf("Expected the download URL to outlive the upload URL, got %v", expires)
+	}
+
+	contentMD5, _ := ContentMD5Header(md5)
+	expected := []string{
+		stringToSign("PUT", contentMD5, "text/plain", strconv.FormatInt(expires["put"], 10), "", "/bucket/object"),
+		stringToSign("GET", "", "", strconv.FormatInt(expires["get"], 10), "", "/bucket/object"),
+	}
+	if len(signer.signed) != 2 || signer.signed[0] != expected[0] || signer.signed[1] != expected[1] {
+		t.Errorf("Expected the signer to sign %q, got %q", expected, signer.signed)
+	}
+}
+
+// signBlobTransport fakes the IAM Service Account Credentials API's signBlob
+// method.
+type signBlobTransport struct {
+	t *testing.T
+}
+
+func (tr *signBlobTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if want := "/v1/projects/-/serviceAccounts/signer@example.iam.gserviceaccount.com:signBlob"; req.URL.Path != want {
+		tr.t.Errorf("Expected path %v, got %v", want, req.URL.Path)
+	}
+	var in struct {
+		Payload string `json:"payload"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&in); err != nil {
+		tr.t.Error(err)
+	}
+	payload, _ := base64.StdEncoding.DecodeString(in.Payload)
+	b, _ := json.Marshal(map[string]string{
+		"keyId":      "key",
+		"signedBlob": base64.StdEncoding.EncodeToString([]byte("signed " + string(payload))),
+	})
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader(string(b))),
+		Request:    req,
+	}, nil
+}
+
+func TestIAMSigner(t *testing.T) {
+	src := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "token"})
+	signer := &IAMSigner{
+		Email:  "signer@example.iam.gserviceaccount.com",
+		Client: googleapiclient.NewClientFromTokenSource(src, &signBlobTransport{t: t}),
+	}
+	sig, err := signer.SignBytes(context.Background(), []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(sig) != "signed data" {
+		t.Errorf("Unexpected signature %q", sig)
+	}
+}
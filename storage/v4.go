@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"golang.org/x/net/context"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error code that indicates a signed URL TTL is longer than the signing
+// scheme allows.
+var ErrTTLTooLong = errors.New("ErrTTLTooLong")
+
+// The longest a V4 signed URL can be valid for.
+const v4MaxTTL = 7 * 24 * time.Hour
+
+// generateSignedURLV4 is like generateSignedURLs, but uses the V4 signing scheme.
+// ttl must not be longer than v4MaxTTL.
+// https://cloud.google.com/storage/docs/access-control/signing-urls-manually
+func generateSignedURLV4(c context.Context, p *urlParams, now time.Time, ttl time.Duration) (string, error) {
+	u, err := url.Parse(p.host)
+	if err != nil {
+		return "", err
+	}
+
+	now = now.UTC()
+	scope := now.Format("20060102") + "/auto/storage/goog4_request"
+	headers := map[string]string{"host": u.Host}
+	if p.contentMD5 != "" {
+		headers["content-md5"] = p.contentMD5
+	}
+	if p.contentType != "" {
+		headers["content-type"] = p.contentType
+	}
+	for name, vals := range p.headers {
+		name = strings.ToLower(name)
+		for _, v := range vals {
+			if headers[name] != "" {
+				headers[name] += ","
+			}
+			headers[name] += strings.TrimSpace(v)
+		}
+	}
+	canonicalHeaders, signedHeaders := canonicalHeadersV4(headers)
+	query := url.Values{
+		"X-Goog-Algorithm":     {"GOOG4-RSA-SHA256"},
+		"X-Goog-Credential":    {p.googleAccessID + "/" + scope},
+		"X-Goog-Date":          {now.Format("20060102T150405Z")},
+		"X-Goog-Expires":       {strconv.FormatInt(int64(ttl/time.Second), 10)},
+		"X-Goog-SignedHeaders": {signedHeaders},
+	}
+	for k, v := range p.query {
+		query[k] = v
+	}
+	canonicalQuery := canonicalQueryV4(query)
+
+	canonicalRequest := canonicalRequestV4(p.httpVerb, p.resource, canonicalQuery, canonicalHeaders, signedHeaders)
+	unsigned := stringToSignV4(now, scope, canonicalRequest)
+	b, err := p.signer.SignBytes(c, []byte(unsigned))
+	if err != nil {
+		return "", err
+	}
+	sig := hex.EncodeToString(b)
+	return p.host + p.resource + "?" + canonicalQuery + "&X-Goog-Signature=" + sig, nil
+}
+
+// canonicalHeadersV4 returns the canonical headers and signed headers
+// components of a V4 canonical request. The header names must be lowercase.
+func canonicalHeadersV4(headers map[string]string) (string, string) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical string
+	for _, name := range names {
+		canonical += name + ":" + strings.TrimSpace(headers[name]) + "\n"
+	}
+	return canonical, strings.Join(names, ";")
+}
+
+// canonicalQueryV4 returns query sorted by name with names and values
+// percent encoded, as required by V4.
+func canonicalQueryV4(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var params []string
+	for _, name := range names {
+		vals := append([]string(nil), query[name]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			params = append(params, escapeV4(name)+"="+escapeV4(v))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+// escapeV4 percent encodes everything except the unreserved characters.
+func escapeV4(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// canonicalRequestV4 returns the canonical request that is hashed into the
+// V4 string to sign. The payload of a signed URL is never signed.
+func canonicalRequestV4(httpVerb, resource, canonicalQuery, canonicalHeaders, signedHeaders string) string {
+	components := []string{
+		httpVerb,
+		resource,
+		canonicalQuery,
+		canonicalHeaders, // Each header ends with a newline, leaving a blank line.
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}
+	return strings.Join(components, "\n")
+}
+
+// stringToSignV4 returns the string that is signed for a V4 signed URL.
+func stringToSignV4(now time.Time, scope, canonicalRequest string) string {
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	components := []string{
+		"GOOG4-RSA-SHA256",
+		now.UTC().Format("20060102T150405Z"),
+		scope,
+		hex.EncodeToString(hashed[:]),
+	}
+	return strings.Join(components, "\n")
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/aetest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCanonicalRequestV4(t *testing.T) {
+	headers, signed := canonicalHeadersV4(map[string]string{
+		"host":         "storage.googleapis.com",
+		"content-type": " text/plain ",
+	})
+	if headers != "content-type:text/plain\nhost:storage.googleapis.com\n" {
+		t.Fatalf("Unexpected canonical headers %q", headers)
+	}
+	if signed != "content-type;host" {
+		t.Fatalf("Unexpected signed headers %q", signed)
+	}
+
+	query := canonicalQueryV4(url.Values{
+		"X-Goog-Expires":    {"60"},
+		"X-Goog-Credential": {"sa@example.com/20170714/auto/storage/goog4_request"},
+		"a b":               {"c d"},
+	})
+	expectedQuery := "X-Goog-Credential=sa%40example.com%2F20170714%2Fauto%2Fstorage%2Fgoog4_request&X-Goog-Expires=60&a%20b=c%20d"
+	if query != expectedQuery {
+		t.Fatalf("Expected query %q but got %q", expectedQuery, query)
+	}
+
+	request := canonicalRequestV4("PUT", "/bucket/object", query, headers, signed)
+	expectedRequest := "PUT\n/bucket/object\n" + expectedQuery + "\ncontent-type:text/plain\nhost:storage.googleapis.com\n\ncontent-type;host\nUNSIGNED-PAYLOAD"
+	if request != expectedRequest {
+		t.Fatalf("Expected canonical request %q but got %q", expectedRequest, request)
+	}
+
+	now := time.Date(2017, 7, 14, 1, 2, 3, 0, time.UTC)
+	s := stringToSignV4(now, "20170714/auto/storage/goog4_request", "")
+	expected := "GOOG4-RSA-SHA256\n20170714T010203Z\n20170714/auto/storage/goog4_request\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if s != expected {
+		t.Fatalf("Expected string to sign %q but got %q", expected, s)
+	}
+}
+
+func TestSignedURLV4TTLTooLong(t *testing.T) {
+	bo := &BucketObject{Bucket: "bucket", Object: "object"}
+	_, err := bo.SignedURL(context.Background(), &SignedURLOptions{
+		Method:  "GET",
+		TTL:     8 * 24 * time.Hour,
+		Version: V4,
+	})
+	if err != ErrTTLTooLong {
+		t.Fatalf("Expected ErrTTLTooLong but got %v", err)
+	}
+}
+
+func TestSignedURLV4(t *testing.T) {
+	c, closer, err := aetest.NewContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer()
+
+	bo := &BucketObject{Bucket: "bucket", Object: "object"}
+	signed, err := bo.SignedURL(c, &SignedURLOptions{
+		Method:      "PUT",
+		TTL:         1 * time.Hour,
+		ContentType: "text/plain",
+		Version:     V4,
+	})
+	if err != nil {
+		t.Fatalf("Failed to sign URL. %v", err)
+	}
+	if !strings.HasPrefix(signed, "https://storage.googleapis.com/bucket/object?") {
+		t.Fatalf("Unexpected URL %v", signed)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("Failed to parse signed URL. %v", err)
+	}
+	q := u.Query()
+	if q.Get("X-Goog-Algorithm") != "GOOG4-RSA-SHA256" || q.Get("X-Goog-Expires") != "3600" || q.Get("X-Goog-SignedHeaders") != "content-type;host" {
+		t.Fatalf("Unexpected query parameters %v", q)
+	}
+	for _, param := range []string{"X-Goog-Credential", "X-Goog-Date", "X-Goog-Signature"} {
+		if q.Get(param) == "" {
+			t.Errorf("Expected %v query parameter in %v", param, signed)
+		}
+	}
+}
+
+func TestSignedURLV4ExtraQuery(t *testing.T) {
+	signer := &mockSigner{}
+	bo := &BucketObject{Bucket: "bucket", Object: "object"}
+	signed, err := bo.SignedURL(context.Background(), &SignedURLOptions{
+		Method:     "GET",
+		TTL:        1 * time.Hour,
+		Signer:     signer,
+		Version:    V4,
+		ExtraQuery: url.Values{"alt": {"media"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to sign URL. %v", err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("alt") != "media" {
+		t.Fatalf("Expected alt=media in %v", signed)
+	}
+
+	// The string to sign must cover the canonical request with alt=media in
+	// its sorted position in the query.
+	q.Del("X-Goog-Signature")
+	canonicalQuery := canonicalQueryV4(q)
+	if !strings.HasPrefix(canonicalQuery, "X-Goog-Algorithm=") || !strings.HasSuffix(canonicalQuery, "&alt=media") {
+		t.Fatalf("Unexpected canonical query %q", canonicalQuery)
+	}
+	now, err := time.Parse("20060102T150405Z", q.Get("X-Goog-Date"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := canonicalRequestV4("GET", "/bucket/object", canonicalQuery, "host:storage.googleapis.com\n", "host")
+	expected := stringToSignV4(now, now.Format("20060102")+"/auto/storage/goog4_request", request)
+	if len(signer.signed) != 1 || signer.signed[0] != expected {
+		t.Fatalf("Expected the signer to sign %q, got %q", expected, signer.signed)
+	}
+
+	tests := []struct {
+		opts *SignedURLOptions
+		err  error
+	}{
+		{&SignedURLOptions{Method: "GET", TTL: time.Hour, Signer: signer, ExtraQuery: url.Values{"alt": {"media"}}}, ErrQueryRequiresV4},
+		{&SignedURLOptions{Method: "GET", TTL: time.Hour, Signer: signer, Version: V4, ExtraQuery: url.Values{"x-goog-signature": {"forged"}}}, ErrReservedQueryParameter},
+	}
+	for _, test := range tests {
+		if _, err := bo.SignedURL(context.Background(), test.opts); err != test.err {
+			t.Errorf("Expected %v but got %v", test.err, err)
+		}
+	}
+}